@@ -1,7 +1,9 @@
 package mysql
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/xml"
 	"errors"
@@ -163,6 +165,162 @@ func (ds *Datastore) MDMWindowsInsertCommandForHosts(ctx context.Context, hostUU
 	})
 }
 
+// MDMWindowsBulkInsertCommandsForHosts enqueues cmds for every enrollment that
+// matches target in a single transaction. Unlike MDMWindowsInsertCommandForHosts,
+// which issues one command to many hosts, this allows enqueuing many distinct
+// commands in bulk (e.g. one command per host, or a handful of commands
+// fanned out to thousands of hosts) without a per-host round trip.
+//
+// Enrollments are resolved from target (host UUIDs, device IDs, team IDs or a
+// label) in a single query, and the commands plus their queue entries are
+// inserted using chunked multi-row INSERTs to stay under MySQL's
+// max_allowed_packet.
+func (ds *Datastore) MDMWindowsBulkInsertCommandsForHosts(ctx context.Context, target fleet.MDMWindowsBulkCommandTarget, cmds []*fleet.MDMWindowsCommand) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		enrollmentIDs, err := ds.mdmWindowsResolveTargetEnrollmentIDsDB(ctx, tx, target)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "resolving windows mdm bulk command target")
+		}
+		if len(enrollmentIDs) == 0 {
+			return nil
+		}
+
+		batchSize := mdmWindowsBulkCommandBatchSize
+		if ds.testMDMWindowsBulkCommandBatchSize > 0 {
+			batchSize = ds.testMDMWindowsBulkCommandBatchSize
+		}
+
+		for i := 0; i < len(cmds); i += batchSize {
+			end := i + batchSize
+			if end > len(cmds) {
+				end = len(cmds)
+			}
+			if err := ds.mdmWindowsBulkInsertCommandsBatchDB(ctx, tx, enrollmentIDs, cmds[i:end], batchSize); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// mdmWindowsBulkCommandBatchSize caps the number of rows inserted per
+// statement so that a single INSERT stays comfortably under MySQL's default
+// max_allowed_packet. It bounds both the windows_mdm_commands INSERT
+// (len(cmds) per statement) and the windows_mdm_command_queue INSERT, which
+// is chunked independently since its row count is the cross-product
+// len(cmds) * len(enrollmentIDs).
+const mdmWindowsBulkCommandBatchSize = 500
+
+func (ds *Datastore) mdmWindowsResolveTargetEnrollmentIDsDB(
+	ctx context.Context,
+	tx sqlx.ExtContext,
+	target fleet.MDMWindowsBulkCommandTarget,
+) ([]uint, error) {
+	var (
+		stmt string
+		args []any
+		err  error
+	)
+
+	switch {
+	case len(target.HostUUIDs) > 0:
+		stmt, args, err = sqlx.In(`SELECT id FROM mdm_windows_enrollments WHERE host_uuid IN (?)`, target.HostUUIDs)
+	case len(target.DeviceIDs) > 0:
+		stmt, args, err = sqlx.In(`SELECT id FROM mdm_windows_enrollments WHERE mdm_device_id IN (?)`, target.DeviceIDs)
+	case len(target.TeamIDs) > 0:
+		stmt, args, err = sqlx.In(`
+SELECT mwe.id
+FROM mdm_windows_enrollments mwe
+INNER JOIN hosts h ON h.uuid = mwe.host_uuid
+WHERE h.team_id IN (?)`, target.TeamIDs)
+	case target.LabelID != nil:
+		stmt = `
+SELECT mwe.id
+FROM mdm_windows_enrollments mwe
+INNER JOIN hosts h ON h.uuid = mwe.host_uuid
+INNER JOIN label_membership lm ON lm.host_id = h.id
+WHERE lm.label_id = ?`
+		args = []any{*target.LabelID}
+	default:
+		return nil, ctxerr.New(ctx, "MDMWindowsBulkCommandTarget must specify host UUIDs, device IDs, team IDs or a label")
+	}
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "building windows mdm bulk command target query")
+	}
+
+	var ids []uint
+	if err := sqlx.SelectContext(ctx, tx, &ids, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "resolving windows mdm enrollments for bulk command target")
+	}
+	return ids, nil
+}
+
+func (ds *Datastore) mdmWindowsBulkInsertCommandsBatchDB(
+	ctx context.Context,
+	tx sqlx.ExtContext,
+	enrollmentIDs []uint,
+	cmds []*fleet.MDMWindowsCommand,
+	batchSize int,
+) error {
+	var cmdArgs []any
+	var cmdSb strings.Builder
+	for _, cmd := range cmds {
+		cmdArgs = append(cmdArgs, cmd.CommandUUID, cmd.RawCommand, cmd.TargetLocURI)
+		cmdSb.WriteString("(?, ?, ?),")
+	}
+	cmdStmt := fmt.Sprintf(`
+INSERT INTO windows_mdm_commands (command_uuid, raw_command, target_loc_uri)
+VALUES %s`, strings.TrimSuffix(cmdSb.String(), ","))
+	if _, err := tx.ExecContext(ctx, cmdStmt, cmdArgs...); err != nil {
+		if isDuplicate(err) {
+			return ctxerr.Wrap(ctx, alreadyExists("MDMWindowsCommand", cmds[0].CommandUUID))
+		}
+		return ctxerr.Wrap(ctx, err, "bulk inserting MDMWindowsCommand")
+	}
+
+	// The queue has one row per (enrollment, command) pair, so its row count
+	// is the cross-product len(enrollmentIDs) * len(cmds), not len(cmds). It
+	// must be chunked on its own to stay under max_allowed_packet even when
+	// cmds is a single small batch but enrollmentIDs is large.
+	type queueRow struct {
+		enrollmentID uint
+		commandUUID  string
+	}
+	queueRows := make([]queueRow, 0, len(enrollmentIDs)*len(cmds))
+	for _, enrollmentID := range enrollmentIDs {
+		for _, cmd := range cmds {
+			queueRows = append(queueRows, queueRow{enrollmentID, cmd.CommandUUID})
+		}
+	}
+
+	for i := 0; i < len(queueRows); i += batchSize {
+		end := i + batchSize
+		if end > len(queueRows) {
+			end = len(queueRows)
+		}
+		chunk := queueRows[i:end]
+
+		var queueArgs []any
+		var queueSb strings.Builder
+		for _, row := range chunk {
+			queueArgs = append(queueArgs, row.enrollmentID, row.commandUUID)
+			queueSb.WriteString("(?, ?),")
+		}
+		queueStmt := fmt.Sprintf(`
+INSERT INTO windows_mdm_command_queue (enrollment_id, command_uuid)
+VALUES %s`, strings.TrimSuffix(queueSb.String(), ","))
+		if _, err := tx.ExecContext(ctx, queueStmt, queueArgs...); err != nil {
+			return ctxerr.Wrap(ctx, err, "bulk inserting MDMWindowsCommandQueue")
+		}
+	}
+
+	return nil
+}
+
 func (ds *Datastore) mdmWindowsInsertHostCommandDB(ctx context.Context, tx sqlx.ExecerContext, hostUUIDOrDeviceID, commandUUID string) error {
 	stmt := `
 INSERT INTO windows_mdm_command_queue (enrollment_id, command_uuid)
@@ -179,12 +337,10 @@ VALUES ((SELECT id FROM mdm_windows_enrollments WHERE host_uuid = ? OR mdm_devic
 	return nil
 }
 
-// MDMWindowsGetPendingCommands retrieves all commands awaiting execution for a
-// given device ID.
-func (ds *Datastore) MDMWindowsGetPendingCommands(ctx context.Context, deviceID string) ([]*fleet.MDMWindowsCommand, error) {
-	var commands []*fleet.MDMWindowsCommand
-
-	query := `
+// mdmWindowsPendingCommandsQuery is shared by MDMWindowsGetPendingCommands
+// and StreamMDMWindowsPendingCommands. %s is filled in with an optional
+// cursor predicate on wmc.command_uuid.
+const mdmWindowsPendingCommandsQuery = `
 SELECT
 	wmc.command_uuid,
 	wmc.raw_command,
@@ -211,25 +367,149 @@ WHERE
 			wmcr.enrollment_id = wmcq.enrollment_id AND
 			wmcr.command_uuid = wmcq.command_uuid
 	)
+	%s
+ORDER BY
+	wmc.command_uuid
+%s
 `
 
-	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &commands, query, deviceID); err != nil {
+// MDMWindowsGetPendingCommands retrieves all commands awaiting execution for a
+// given device ID. This is the original, unbounded fleet.Datastore method
+// signature; see MDMWindowsGetPendingCommandsWithCursor for a paginated
+// variant.
+func (ds *Datastore) MDMWindowsGetPendingCommands(ctx context.Context, deviceID string) ([]*fleet.MDMWindowsCommand, error) {
+	return ds.MDMWindowsGetPendingCommandsWithCursor(ctx, deviceID, fleet.MDMWindowsCommandCursorPage{})
+}
+
+// MDMWindowsGetPendingCommandsWithCursor is MDMWindowsGetPendingCommands with
+// optional cursor pagination: if opts.After is set, only commands with a
+// command_uuid greater than it are returned; if opts.Limit is set, at most
+// that many rows are returned. A zero-value opts behaves exactly like
+// MDMWindowsGetPendingCommands. This is a new method rather than a change to
+// MDMWindowsGetPendingCommands' signature so it doesn't require updating
+// fleet.Datastore and its existing callers.
+func (ds *Datastore) MDMWindowsGetPendingCommandsWithCursor(ctx context.Context, deviceID string, opts fleet.MDMWindowsCommandCursorPage) ([]*fleet.MDMWindowsCommand, error) {
+	var commands []*fleet.MDMWindowsCommand
+
+	args := []any{deviceID}
+	cursor := ""
+	if opts.After != "" {
+		cursor = "AND wmc.command_uuid > ?"
+		args = append(args, opts.After)
+	}
+	limit := ""
+	if opts.Limit > 0 {
+		limit = "LIMIT ?"
+		args = append(args, opts.Limit)
+	}
+	query := fmt.Sprintf(mdmWindowsPendingCommandsQuery, cursor, limit)
+
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &commands, query, args...); err != nil {
 		return nil, ctxerr.Wrap(ctx, err, "get pending Windows MDM commands by device id")
 	}
 
 	return commands, nil
 }
 
+// defaultMDMWindowsResultChunkSize is the number of rows fetched per batch by
+// the Stream* methods below when ds.MDMWindowsResultChunkSize is unset.
+const defaultMDMWindowsResultChunkSize = 500
+
+func (ds *Datastore) mdmWindowsResultChunkSize() int {
+	if ds.MDMWindowsResultChunkSize > 0 {
+		return ds.MDMWindowsResultChunkSize
+	}
+	return defaultMDMWindowsResultChunkSize
+}
+
+// StreamMDMWindowsPendingCommands streams the commands awaiting execution
+// for deviceID to cb, a page at a time, instead of loading the full result
+// set into memory. This is meant for devices (or callers) that may have a
+// very large number of pending commands. cb is called once per command; an
+// error returned from cb aborts the stream.
+func (ds *Datastore) StreamMDMWindowsPendingCommands(ctx context.Context, deviceID string, cb func(*fleet.MDMWindowsCommand) error) error {
+	chunkSize := ds.mdmWindowsResultChunkSize()
+	after := ""
+	for {
+		args := []any{deviceID}
+		cursor := ""
+		if after != "" {
+			cursor = "AND wmc.command_uuid > ?"
+			args = append(args, after)
+		}
+		query := fmt.Sprintf(mdmWindowsPendingCommandsQuery, cursor, "LIMIT ?")
+		args = append(args, chunkSize)
+
+		rows, err := ds.reader(ctx).QueryxContext(ctx, ds.reader(ctx).Rebind(query), args...)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "querying pending Windows MDM commands")
+		}
+
+		var n int
+		for rows.Next() {
+			var cmd fleet.MDMWindowsCommand
+			if err := rows.StructScan(&cmd); err != nil {
+				rows.Close()
+				return ctxerr.Wrap(ctx, err, "scanning pending Windows MDM command")
+			}
+			n++
+			after = cmd.CommandUUID
+			if err := cb(&cmd); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		closeErr := rows.Close()
+		if err := rows.Err(); err != nil {
+			return ctxerr.Wrap(ctx, err, "iterating pending Windows MDM commands")
+		}
+		if closeErr != nil {
+			return ctxerr.Wrap(ctx, closeErr, "closing pending Windows MDM commands rows")
+		}
+
+		if n < chunkSize {
+			return nil
+		}
+	}
+}
+
 func (ds *Datastore) MDMWindowsSaveResponse(ctx context.Context, deviceID string, fullResponse *fleet.SyncML) error {
 	if len(fullResponse.Raw) == 0 {
 		return ctxerr.New(ctx, "empty raw response")
 	}
 
-	const findCommandsStmt = `SELECT command_uuid, raw_command FROM windows_mdm_commands WHERE command_uuid IN (?)`
+	// findCommandsStmt reads command_state off windows_mdm_command_queue,
+	// not windows_mdm_commands: a single command_uuid is fanned out to
+	// every targeted enrollment, so windows_mdm_commands' command_state is
+	// shared across all of them, while the queue row is scoped to this
+	// enrollment alone.
+	const findCommandsStmt = `
+SELECT wmc.command_uuid, wmc.raw_command, wmcq.command_state
+FROM windows_mdm_commands wmc
+INNER JOIN windows_mdm_command_queue wmcq
+    ON wmcq.command_uuid = wmc.command_uuid AND wmcq.enrollment_id = ?
+WHERE wmc.command_uuid IN (?)`
 
 	const saveFullRespStmt = `INSERT INTO windows_mdm_responses (enrollment_id, raw_response) VALUES (?, ?)`
 
-	const dequeueCommandsStmt = `DELETE FROM windows_mdm_command_queue WHERE command_uuid IN (?)`
+	const dequeueCommandsStmt = `DELETE FROM windows_mdm_command_queue WHERE enrollment_id = ? AND command_uuid IN (?)`
+
+	// casCommandStateStmt is the compare-and-swap used to advance a
+	// command's state: it only succeeds if command_state still matches the
+	// version we read earlier in this transaction, which tells us no other
+	// response (a replay, or a racing retry from the device) has already
+	// committed results for this command. It's scoped to this enrollment's
+	// queue row, not the command as a whole, so one host's response can't
+	// starve another host's response to the same fanned-out command.
+	const casCommandStateStmt = `
+UPDATE windows_mdm_command_queue
+SET command_state = command_state + 1
+WHERE enrollment_id = ? AND command_uuid = ? AND command_state = ?`
+
+	const insertConflictStmt = `
+INSERT INTO windows_mdm_command_conflicts
+    (enrollment_id, command_uuid, response_id, expected_state, raw_result, status_code, conflict_type)
+VALUES (?, ?, ?, ?, ?, ?, ?)`
 
 	const insertResultsStmt = `
 INSERT INTO windows_mdm_command_results
@@ -281,7 +561,7 @@ ON DUPLICATE KEY UPDATE
 		responseID, _ := sqlResult.LastInsertId()
 
 		// find commands we sent that match the UUID responses we've got
-		stmt, params, err := sqlx.In(findCommandsStmt, cmdUUIDs)
+		stmt, params, err := sqlx.In(findCommandsStmt, enrollment.ID, cmdUUIDs)
 		if err != nil {
 			return ctxerr.Wrap(ctx, err, "building IN to search matching commands")
 		}
@@ -297,7 +577,11 @@ ON DUPLICATE KEY UPDATE
 		}
 
 		// for all the matching UUIDs, try to find any <Status> or
-		// <Result> entries to track them as responses.
+		// <Result> entries to track them as responses. Each command is
+		// first advanced with a compare-and-swap on its command_state; a
+		// command that loses the race (a late duplicate, or a response
+		// that conflicts with one already committed) is routed to the
+		// conflicts table instead of silently overwriting prior results.
 		var args []any
 		var sb strings.Builder
 		var potentialProfilePayloads []*fleet.MDMWindowsProfilePayload
@@ -305,13 +589,6 @@ ON DUPLICATE KEY UPDATE
 			statusCode := ""
 			if status, ok := uuidsToStatus[cmd.CommandUUID]; ok && status.Data != nil {
 				statusCode = *status.Data
-				if status.Cmd != nil && *status.Cmd == fleet.CmdAtomic {
-					pp, err := fleet.BuildMDMWindowsProfilePayloadFromMDMResponse(cmd, uuidsToStatus, enrollment.HostUUID)
-					if err != nil {
-						return err
-					}
-					potentialProfilePayloads = append(potentialProfilePayloads, pp)
-				}
 			}
 
 			rawResult := []byte{}
@@ -322,6 +599,38 @@ ON DUPLICATE KEY UPDATE
 					ds.logger.Log("err", err, "marshaling command result", "cmd_uuid", cmd.CommandUUID)
 				}
 			}
+
+			casResult, err := tx.ExecContext(ctx, casCommandStateStmt, enrollment.ID, cmd.CommandUUID, cmd.CommandState)
+			if err != nil {
+				return ctxerr.Wrap(ctx, err, "advancing command state")
+			}
+			affected, _ := casResult.RowsAffected()
+			if affected == 0 {
+				conflictType := fleet.MDMWindowsCommandConflictStatus
+				if _, ok := uuidsToResults[cmd.CommandUUID]; !ok {
+					conflictType = fleet.MDMWindowsCommandConflictLateDuplicate
+				}
+				if _, err := tx.ExecContext(
+					ctx, insertConflictStmt,
+					enrollment.ID, cmd.CommandUUID, responseID, cmd.CommandState, rawResult, statusCode, conflictType,
+				); err != nil {
+					return ctxerr.Wrap(ctx, err, "recording windows mdm command conflict")
+				}
+				continue
+			}
+
+			if status, ok := uuidsToStatus[cmd.CommandUUID]; ok && status.Data != nil && status.Cmd != nil {
+				if h := lookupMDMWindowsCommandHandler(fleet.CmdStatus, *status.Cmd); h != nil {
+					pp, err := h(ctx, tx, enrollment, cmd, uuidsToStatus)
+					if err != nil {
+						return err
+					}
+					if pp != nil {
+						potentialProfilePayloads = append(potentialProfilePayloads, pp)
+					}
+				}
+			}
+
 			args = append(args, enrollment.ID, cmd.CommandUUID, rawResult, responseID, statusCode)
 			sb.WriteString("(?, ?, ?, ?, ?),")
 		}
@@ -330,10 +639,12 @@ ON DUPLICATE KEY UPDATE
 			return ctxerr.Wrap(ctx, err, "updating host profile status")
 		}
 
-		// store the command results
-		stmt = fmt.Sprintf(insertResultsStmt, strings.TrimSuffix(sb.String(), ","))
-		if _, err = tx.ExecContext(ctx, stmt, args...); err != nil {
-			return ctxerr.Wrap(ctx, err, "inserting command results")
+		// store the command results for the commands that won their CAS
+		if len(args) > 0 {
+			stmt = fmt.Sprintf(insertResultsStmt, strings.TrimSuffix(sb.String(), ","))
+			if _, err = tx.ExecContext(ctx, stmt, args...); err != nil {
+				return ctxerr.Wrap(ctx, err, "inserting command results")
+			}
 		}
 
 		// dequeue the commands
@@ -341,7 +652,7 @@ ON DUPLICATE KEY UPDATE
 		for _, cmd := range matchingCmds {
 			matchingUUIDs = append(matchingUUIDs, cmd.CommandUUID)
 		}
-		stmt, params, err = sqlx.In(dequeueCommandsStmt, matchingUUIDs)
+		stmt, params, err = sqlx.In(dequeueCommandsStmt, enrollment.ID, matchingUUIDs)
 		if err != nil {
 			return ctxerr.Wrap(ctx, err, "building IN to dequeue commands")
 		}
@@ -353,6 +664,35 @@ ON DUPLICATE KEY UPDATE
 	})
 }
 
+// GetMDMWindowsCommandConflicts returns the command/response conflicts
+// recorded for hostUUID, most recent first, so operators can debug races
+// between device retries and server-side timeouts.
+func (ds *Datastore) GetMDMWindowsCommandConflicts(ctx context.Context, hostUUID string) ([]*fleet.MDMWindowsCommandConflict, error) {
+	stmt := `
+SELECT
+    wmcc.id,
+    wmcc.command_uuid,
+    wmcc.expected_state,
+    wmcc.raw_result,
+    wmcc.status_code,
+    wmcc.conflict_type,
+    wmcc.created_at
+FROM
+    windows_mdm_command_conflicts wmcc
+INNER JOIN
+    mdm_windows_enrollments mwe ON mwe.id = wmcc.enrollment_id
+WHERE
+    mwe.host_uuid = ?
+ORDER BY
+    wmcc.created_at DESC`
+
+	var conflicts []*fleet.MDMWindowsCommandConflict
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &conflicts, stmt, hostUUID); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "get windows mdm command conflicts")
+	}
+	return conflicts, nil
+}
+
 // updateMDMWindowsHostProfileStatusFromResponseDB takes a slice of potential
 // profile payloads and updates the corresponding `status` and `detail` columns
 // in `host_mdm_windows_profiles`
@@ -423,8 +763,11 @@ func updateMDMWindowsHostProfileStatusFromResponseDB(
 	return ctxerr.Wrap(ctx, err, "updating host profiles")
 }
 
-func (ds *Datastore) GetMDMWindowsCommandResults(ctx context.Context, commandUUID string) ([]*fleet.MDMCommandResult, error) {
-	query := `
+// mdmWindowsCommandResultsQuery is shared by GetMDMWindowsCommandResults and
+// StreamMDMWindowsCommandResults. %s is filled in with an optional cursor
+// predicate and LIMIT clause, ordered by host_uuid so that pagination and
+// streaming can resume from after_host_uuid.
+const mdmWindowsCommandResultsQuery = `
 SELECT
     mwe.host_uuid,
     wmcr.command_uuid,
@@ -448,23 +791,99 @@ ON
     wmr.id = wmcr.response_id
 WHERE
     wmcr.command_uuid = ?
+    %s
+ORDER BY
+    mwe.host_uuid
+%s
 `
 
+// GetMDMWindowsCommandResults returns the results for commandUUID. This is
+// the original, unbounded fleet.Datastore method signature; see
+// GetMDMWindowsCommandResultsWithCursor for a paginated variant.
+func (ds *Datastore) GetMDMWindowsCommandResults(ctx context.Context, commandUUID string) ([]*fleet.MDMCommandResult, error) {
+	return ds.GetMDMWindowsCommandResultsWithCursor(ctx, commandUUID, fleet.MDMWindowsCommandCursorPage{})
+}
+
+// GetMDMWindowsCommandResultsWithCursor is GetMDMWindowsCommandResults with
+// optional cursor pagination: if opts.After is set, only rows for a
+// host_uuid greater than it are returned; if opts.Limit is set, at most that
+// many rows are returned. A zero-value opts behaves exactly like
+// GetMDMWindowsCommandResults. This is a new method rather than a change to
+// GetMDMWindowsCommandResults' signature so it doesn't require updating
+// fleet.Datastore and its existing callers.
+func (ds *Datastore) GetMDMWindowsCommandResultsWithCursor(ctx context.Context, commandUUID string, opts fleet.MDMWindowsCommandCursorPage) ([]*fleet.MDMCommandResult, error) {
+	args := []any{commandUUID}
+	cursor := ""
+	if opts.After != "" {
+		cursor = "AND mwe.host_uuid > ?"
+		args = append(args, opts.After)
+	}
+	limit := ""
+	if opts.Limit > 0 {
+		limit = "LIMIT ?"
+		args = append(args, opts.Limit)
+	}
+	query := fmt.Sprintf(mdmWindowsCommandResultsQuery, cursor, limit)
+
 	var results []*fleet.MDMCommandResult
-	err := sqlx.SelectContext(
-		ctx,
-		ds.reader(ctx),
-		&results,
-		query,
-		commandUUID,
-	)
-	if err != nil {
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &results, query, args...); err != nil {
 		return nil, ctxerr.Wrap(ctx, err, "get command results")
 	}
 
 	return results, nil
 }
 
+// StreamMDMWindowsCommandResults streams the results for commandUUID to cb,
+// a page at a time, instead of loading the full result set into memory. This
+// is meant for commands that have been broadcast to a very large number of
+// hosts. cb is called once per result; an error returned from cb aborts the
+// stream.
+func (ds *Datastore) StreamMDMWindowsCommandResults(ctx context.Context, commandUUID string, cb func(*fleet.MDMCommandResult) error) error {
+	chunkSize := ds.mdmWindowsResultChunkSize()
+	after := ""
+	for {
+		args := []any{commandUUID}
+		cursor := ""
+		if after != "" {
+			cursor = "AND mwe.host_uuid > ?"
+			args = append(args, after)
+		}
+		query := fmt.Sprintf(mdmWindowsCommandResultsQuery, cursor, "LIMIT ?")
+		args = append(args, chunkSize)
+
+		rows, err := ds.reader(ctx).QueryxContext(ctx, ds.reader(ctx).Rebind(query), args...)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "querying Windows MDM command results")
+		}
+
+		var n int
+		for rows.Next() {
+			var result fleet.MDMCommandResult
+			if err := rows.StructScan(&result); err != nil {
+				rows.Close()
+				return ctxerr.Wrap(ctx, err, "scanning Windows MDM command result")
+			}
+			n++
+			after = result.HostUUID
+			if err := cb(&result); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		closeErr := rows.Close()
+		if err := rows.Err(); err != nil {
+			return ctxerr.Wrap(ctx, err, "iterating Windows MDM command results")
+		}
+		if closeErr != nil {
+			return ctxerr.Wrap(ctx, closeErr, "closing Windows MDM command results rows")
+		}
+
+		if n < chunkSize {
+			return nil
+		}
+	}
+}
+
 func (ds *Datastore) UpdateMDMWindowsEnrollmentsHostUUID(ctx context.Context, hostUUID string, mdmDeviceID string) error {
 	stmt := `UPDATE mdm_windows_enrollments SET host_uuid = ? WHERE mdm_device_id = ?`
 	if _, err := ds.writer(ctx).Exec(stmt, hostUUID, mdmDeviceID); err != nil {
@@ -480,63 +899,30 @@ func (ds *Datastore) UpdateMDMWindowsEnrollmentsHostUUID(ctx context.Context, ho
 // - host_disk_encryption_keys: hdek
 // - host_mdm: hmdm
 // - host_disks: hd
+//
+// The actual predicate is produced by ds.bitLockerStatusEvaluator, which defaults to
+// defaultBitLockerStatusEvaluator but can be swapped out via SetBitLockerStatusEvaluator.
 func (ds *Datastore) whereBitLockerStatus(status fleet.DiskEncryptionStatus) string {
-	const (
-		whereNotServer        = `(hmdm.is_server IS NOT NULL AND hmdm.is_server = 0)`
-		whereKeyAvailable     = `(hdek.base64_encrypted IS NOT NULL AND hdek.base64_encrypted != '' AND hdek.decryptable IS NOT NULL AND hdek.decryptable = 1)`
-		whereEncrypted        = `(hd.encrypted IS NOT NULL AND hd.encrypted = 1)`
-		whereHostDisksUpdated = `(hd.updated_at IS NOT NULL AND hdek.updated_at IS NOT NULL AND hd.updated_at >= hdek.updated_at)`
-		whereClientError      = `(hdek.client_error IS NOT NULL AND hdek.client_error != '')`
-		withinGracePeriod     = `(hdek.updated_at IS NOT NULL AND hdek.updated_at >= DATE_SUB(NOW(), INTERVAL 1 HOUR))`
-	)
-
-	// TODO: what if windows sends us a key for an already encrypted volumne? could it get stuck
-	// in pending or verifying? should we modify SetOrUpdateHostDiskEncryption to ensure that we
-	// increment the updated_at timestamp on the host_disks table for all encrypted volumes
-	// host_disks if the hdek timestamp is newer? What about SetOrUpdateHostDiskEncryptionKey?
-
-	switch status {
-	case fleet.DiskEncryptionVerified:
-		return whereNotServer + `
-AND NOT ` + whereClientError + `
-AND ` + whereKeyAvailable + `
-AND ` + whereEncrypted + `
-AND ` + whereHostDisksUpdated
-
-	case fleet.DiskEncryptionVerifying:
-		// Possible verifying scenarios:
-		// - we have the key and host_disks already encrypted before the key but hasn't been updated yet
-		// - we have the key and host_disks reported unencrypted during the 1-hour grace period after key was updated
-		return whereNotServer + `
-AND NOT ` + whereClientError + `
-AND ` + whereKeyAvailable + `
-AND (
-    (` + whereEncrypted + ` AND NOT ` + whereHostDisksUpdated + `)
-    OR (NOT ` + whereEncrypted + ` AND ` + whereHostDisksUpdated + ` AND ` + withinGracePeriod + `)
-)`
-
-	case fleet.DiskEncryptionEnforcing:
-		// Possible enforcing scenarios:
-		// - we don't have the key
-		// - we have the key and host_disks reported unencrypted before the key was updated or outside the 1-hour grace period after key was updated
-		return whereNotServer + `
-AND NOT ` + whereClientError + `
-AND (
-    NOT ` + whereKeyAvailable + `
-    OR (` + whereKeyAvailable + `
-        AND (NOT ` + whereEncrypted + `
-            AND (NOT ` + whereHostDisksUpdated + ` OR NOT ` + withinGracePeriod + `)
-		)
-	)
-)`
-
-	case fleet.DiskEncryptionFailed:
-		return whereNotServer + ` AND ` + whereClientError
+	return ds.bitLockerStatusEvaluator().WhereClause(status)
+}
 
-	default:
-		level.Debug(ds.logger).Log("msg", "unknown bitlocker status", "status", status)
-		return "FALSE"
+// bitLockerStatusEvaluator returns the evaluator to use for BitLocker status
+// predicates, falling back to defaultBitLockerStatusEvaluator if none has
+// been registered.
+func (ds *Datastore) bitLockerStatusEvaluator() BitLockerStatusEvaluator {
+	if ds.bitLockerEvaluator == nil {
+		return defaultBitLockerStatusEvaluator{logger: ds.logger}
 	}
+	return ds.bitLockerEvaluator
+}
+
+// SetBitLockerStatusEvaluator registers e as the evaluator used to build the
+// BitLocker status predicates for GetMDMWindowsBitLockerSummary and
+// GetMDMWindowsBitLockerStatus. This lets downstream deployments inject
+// custom policies (e.g. a longer grace period, or an additional "suspended"
+// state for hosts under maintenance) without forking the datastore.
+func (ds *Datastore) SetBitLockerStatusEvaluator(e BitLockerStatusEvaluator) {
+	ds.bitLockerEvaluator = e
 }
 
 func (ds *Datastore) GetMDMWindowsBitLockerSummary(ctx context.Context, teamID *uint) (*fleet.MDMWindowsBitLockerSummary, error) {
@@ -655,12 +1041,54 @@ WHERE
 		dest.Status = fleet.DiskEncryptionEnforcing
 	}
 
+	if err := ds.recordBitLockerStatusChangeIfNeeded(ctx, host.ID, dest.Status, dest.Detail); err != nil {
+		// The transition audit log is a secondary concern; don't fail the
+		// status read over it, but do leave a trail for debugging.
+		level.Debug(ds.logger).Log("msg", "recording bitlocker status change", "host_id", host.ID, "err", err)
+	}
+
 	return &fleet.HostMDMDiskEncryption{
 		Status: &dest.Status,
 		Detail: dest.Detail,
 	}, nil
 }
 
+// recordBitLockerStatusChangeIfNeeded looks up the most recently recorded
+// BitLocker status for hostID and, if current differs (including the first
+// time a status is seen for the host), appends a row to
+// host_disk_encryption_status_changes with the same INSERT that
+// RecordBitLockerStatusChange runs, issued here inside the transaction. The
+// lookup and the insert both run against the writer, in the same
+// transaction: reading the prior status from ds.reader(ctx) risked a stale
+// read on a lagging replica misdetecting "no prior status" on every
+// concurrent call and recording the same transition more than once.
+func (ds *Datastore) recordBitLockerStatusChangeIfNeeded(ctx context.Context, hostID uint, current fleet.DiskEncryptionStatus, detail string) error {
+	return ds.withTx(ctx, func(tx sqlx.ExtContext) error {
+		var previous fleet.DiskEncryptionStatus
+		err := sqlx.GetContext(ctx, tx, &previous, `
+SELECT current_status FROM host_disk_encryption_status_changes
+WHERE host_id = ? ORDER BY id DESC LIMIT 1`, hostID)
+		switch {
+		case err == sql.ErrNoRows:
+			// no prior status recorded, fall through and record the first one
+		case err != nil:
+			return ctxerr.Wrap(ctx, err, "getting previous bitlocker status")
+		case previous == current:
+			return nil
+		}
+
+		stmt := `
+INSERT INTO host_disk_encryption_status_changes
+    (host_id, previous_status, current_status, detail)
+VALUES
+    (?, ?, ?, ?)`
+		if _, err := tx.ExecContext(ctx, stmt, hostID, previous, current, detail); err != nil {
+			return ctxerr.Wrap(ctx, err, "recording bitlocker status change")
+		}
+		return nil
+	})
+}
+
 func (ds *Datastore) GetMDMWindowsConfigProfile(ctx context.Context, profileUUID string) (*fleet.MDMWindowsConfigProfile, error) {
 	stmt := `
 SELECT
@@ -714,6 +1142,24 @@ func subqueryHostsMDMWindowsOSSettingsStatusFailed() (string, []interface{}) {
 	return sql, args
 }
 
+// subqueryHostsMDMWindowsOSSettingsStatusRetriesExhausted matches hosts with
+// a profile that gave up retrying after MDMWindowsReissueStalledProfiles hit
+// its configured MaxAttempts. It's intentionally its own bucket rather than
+// folded into "failed" so operators can tell "the device rejected this" from
+// "we stopped trying" and filter on the latter for manual follow-up.
+func subqueryHostsMDMWindowsOSSettingsStatusRetriesExhausted() (string, []interface{}) {
+	sql := `
+            SELECT
+                1 FROM host_mdm_windows_profiles hmwp
+            WHERE
+                h.uuid = hmwp.host_uuid
+                AND hmwp.status = ?`
+	args := []interface{}{
+		fleet.MDMDeliveryRetriesExhausted,
+	}
+	return sql, args
+}
+
 func subqueryHostsMDMWindowsOSSettingsStatusPending() (string, []interface{}) {
 	sql := `
             SELECT
@@ -804,6 +1250,8 @@ func (ds *Datastore) GetMDMWindowsProfilesSummary(ctx context.Context, teamID *u
 		switch c.Status {
 		case "failed":
 			res.Failed = c.Count
+		case "retries_exhausted":
+			res.RetriesExhausted = c.Count
 		case "pending":
 			res.Pending = c.Count
 		case "verifying":
@@ -817,6 +1265,23 @@ func (ds *Datastore) GetMDMWindowsProfilesSummary(ctx context.Context, teamID *u
 		}
 	}
 
+	scheduled, err := countMDMWindowsScheduledHostsDB(ctx, ds.reader(ctx), teamID)
+	if err != nil {
+		return nil, err
+	}
+	res.Scheduled = scheduled
+
+	// A host deferred by its rollout window has no status yet, so the
+	// counts above still bucket it under "pending" (a cron expression
+	// can't be evaluated in SQL, see countMDMWindowsScheduledHostsDB).
+	// Move it out of Pending into Scheduled instead of double-counting it
+	// in both.
+	if res.Pending > scheduled {
+		res.Pending -= scheduled
+	} else {
+		res.Pending = 0
+	}
+
 	return &res, nil
 }
 
@@ -829,6 +1294,8 @@ func getMDMWindowsStatusCountsProfilesOnlyDB(ctx context.Context, ds *Datastore,
 	var args []interface{}
 	subqueryFailed, subqueryFailedArgs := subqueryHostsMDMWindowsOSSettingsStatusFailed()
 	args = append(args, subqueryFailedArgs...)
+	subqueryRetriesExhausted, subqueryRetriesExhaustedArgs := subqueryHostsMDMWindowsOSSettingsStatusRetriesExhausted()
+	args = append(args, subqueryRetriesExhaustedArgs...)
 	subqueryPending, subqueryPendingArgs := subqueryHostsMDMWindowsOSSettingsStatusPending()
 	args = append(args, subqueryPendingArgs...)
 	subqueryVerifying, subqueryVeryingingArgs := subqueryHostsMDMWindowsOSSettingsStatusVerifying()
@@ -847,6 +1314,8 @@ SELECT
     CASE
         WHEN EXISTS (%s) THEN
             'failed'
+        WHEN EXISTS (%s) THEN
+            'retries_exhausted'
         WHEN EXISTS (%s) THEN
             'pending'
         WHEN EXISTS (%s) THEN
@@ -870,6 +1339,7 @@ WHERE
 GROUP BY
     status`,
 		subqueryFailed,
+		subqueryRetriesExhausted,
 		subqueryPending,
 		subqueryVerifying,
 		subqueryVerified,
@@ -889,6 +1359,8 @@ func getMDMWindowsStatusCountsProfilesAndBitLockerDB(ctx context.Context, ds *Da
 	var args []interface{}
 	subqueryFailed, subqueryFailedArgs := subqueryHostsMDMWindowsOSSettingsStatusFailed()
 	args = append(args, subqueryFailedArgs...)
+	subqueryRetriesExhausted, subqueryRetriesExhaustedArgs := subqueryHostsMDMWindowsOSSettingsStatusRetriesExhausted()
+	args = append(args, subqueryRetriesExhaustedArgs...)
 	subqueryPending, subqueryPendingArgs := subqueryHostsMDMWindowsOSSettingsStatusPending()
 	args = append(args, subqueryPendingArgs...)
 	subqueryVerifying, subqueryVeryingingArgs := subqueryHostsMDMWindowsOSSettingsStatusVerifying()
@@ -899,6 +1371,8 @@ func getMDMWindowsStatusCountsProfilesAndBitLockerDB(ctx context.Context, ds *Da
 	profilesStatus := fmt.Sprintf(`
         CASE WHEN EXISTS (%s) THEN
             'profiles_failed'
+        WHEN EXISTS (%s) THEN
+            'profiles_retries_exhausted'
         WHEN EXISTS (%s) THEN
             'profiles_pending'
         WHEN EXISTS (%s) THEN
@@ -909,6 +1383,7 @@ func getMDMWindowsStatusCountsProfilesAndBitLockerDB(ctx context.Context, ds *Da
             ''
         END`,
 		subqueryFailed,
+		subqueryRetriesExhausted,
 		subqueryPending,
 		subqueryVerifying,
 		subqueryVerified,
@@ -946,6 +1421,8 @@ SELECT
     CASE (SELECT (%s) FROM hosts h2 WHERE h2.id = h.id)
     WHEN 'profiles_failed' THEN
         'failed'
+    WHEN 'profiles_retries_exhausted' THEN
+        'retries_exhausted'
     WHEN 'profiles_pending' THEN (
         CASE (%s)
         WHEN 'bitlocker_failed' THEN
@@ -1037,15 +1514,20 @@ func listMDMWindowsProfilesToInstallDB(
 	//   and a NULL status. Other statuses mean that the operation is already in
 	//   flight (pending), the operation has been completed but is still subject
 	//   to independent verification by Fleet (verifying), or has reached a terminal
-	//   state (failed or verified). If the profile's content is edited, all relevant hosts will
-	//   be marked as status NULL so that it gets re-installed.
+	//   state (failed or verified).
+	//
+	//   - profiles that are in A and in B, whose checksum no longer matches
+	//   the checksum recorded the last time the host acknowledged it. This
+	//   is what triggers a reinstall when a profile's content is edited,
+	//   regardless of its current status, instead of relying on the cron
+	//   to have reset every affected host's status to NULL first.
 	query := `
         SELECT
             ds.profile_uuid,
             ds.host_uuid,
 	    ds.name as profile_name
         FROM (
-            SELECT mwcp.profile_uuid, mwcp.name, h.uuid as host_uuid
+            SELECT mwcp.profile_uuid, mwcp.name, mwcp.checksum, h.uuid as host_uuid
             FROM mdm_windows_configuration_profiles mwcp
             JOIN hosts h ON h.team_id = mwcp.team_id OR (h.team_id IS NULL AND mwcp.team_id = 0)
             JOIN mdm_windows_enrollments mwe ON mwe.host_uuid = h.uuid
@@ -1057,7 +1539,9 @@ func listMDMWindowsProfilesToInstallDB(
         -- profiles in A but not in B
         ( hmwp.profile_uuid IS NULL AND hmwp.host_uuid IS NULL ) OR
         -- profiles in A and B with operation type "install" and NULL status
-        ( hmwp.host_uuid IS NOT NULL AND hmwp.operation_type = ? AND hmwp.status IS NULL )
+        ( hmwp.host_uuid IS NOT NULL AND hmwp.operation_type = ? AND hmwp.status IS NULL ) OR
+        -- profiles in A and B whose content changed since the host last acknowledged it
+        ( hmwp.host_uuid IS NOT NULL AND hmwp.checksum IS NOT NULL AND hmwp.checksum != ds.checksum )
 `
 
 	hostFilter := "TRUE"
@@ -1076,8 +1560,10 @@ func listMDMWindowsProfilesToInstallDB(
 	}
 
 	var profiles []*fleet.MDMWindowsProfilePayload
-	err = sqlx.SelectContext(ctx, tx, &profiles, query, args...)
-	return profiles, err
+	if err := sqlx.SelectContext(ctx, tx, &profiles, query, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "selecting windows profiles to install")
+	}
+	return filterMDMWindowsProfilesByRolloutSchedule(ctx, tx, profiles)
 }
 
 func (ds *Datastore) ListMDMWindowsProfilesToRemove(ctx context.Context) ([]*fleet.MDMWindowsProfilePayload, error) {
@@ -1146,8 +1632,10 @@ func listMDMWindowsProfilesToRemoveDB(
 	}
 
 	var profiles []*fleet.MDMWindowsProfilePayload
-	err = sqlx.SelectContext(ctx, tx, &profiles, query, args...)
-	return profiles, err
+	if err := sqlx.SelectContext(ctx, tx, &profiles, query, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "selecting windows profiles to remove")
+	}
+	return filterMDMWindowsProfilesByRolloutSchedule(ctx, tx, profiles)
 }
 
 func (ds *Datastore) BulkUpsertMDMWindowsHostProfiles(ctx context.Context, payload []*fleet.MDMWindowsBulkUpsertHostProfilePayload) error {
@@ -1164,7 +1652,8 @@ func (ds *Datastore) BulkUpsertMDMWindowsHostProfiles(ctx context.Context, paylo
 	      operation_type,
 	      detail,
 	      command_uuid,
-	      profile_name
+	      profile_name,
+	      checksum
             )
             VALUES %s
 	    ON DUPLICATE KEY UPDATE
@@ -1172,7 +1661,8 @@ func (ds *Datastore) BulkUpsertMDMWindowsHostProfiles(ctx context.Context, paylo
               operation_type = VALUES(operation_type),
               detail = VALUES(detail),
               profile_name = VALUES(profile_name),
-              command_uuid = VALUES(command_uuid)`,
+              command_uuid = VALUES(command_uuid),
+              checksum = VALUES(checksum)`,
 			strings.TrimSuffix(valuePart, ","),
 		)
 
@@ -1186,7 +1676,7 @@ func (ds *Datastore) BulkUpsertMDMWindowsHostProfiles(ctx context.Context, paylo
 		batchCount int
 	)
 
-	const defaultBatchSize = 1000 // results in this times 9 placeholders
+	const defaultBatchSize = 1000 // results in this times 8 placeholders
 	batchSize := defaultBatchSize
 	if ds.testUpsertMDMDesiredProfilesBatchSize > 0 {
 		batchSize = ds.testUpsertMDMDesiredProfilesBatchSize
@@ -1199,8 +1689,8 @@ func (ds *Datastore) BulkUpsertMDMWindowsHostProfiles(ctx context.Context, paylo
 	}
 
 	for _, p := range payload {
-		args = append(args, p.ProfileUUID, p.HostUUID, p.Status, p.OperationType, p.Detail, p.CommandUUID, p.ProfileName)
-		sb.WriteString("(?, ?, ?, ?, ?, ?, ?),")
+		args = append(args, p.ProfileUUID, p.HostUUID, p.Status, p.OperationType, p.Detail, p.CommandUUID, p.ProfileName, p.Checksum)
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?),")
 		batchCount++
 
 		if batchCount >= batchSize {
@@ -1311,12 +1801,23 @@ func (ds *Datastore) bulkDeleteMDMWindowsHostsConfigProfilesDB(
 	return nil
 }
 
+// mdmWindowsProfileChecksum returns a SHA-256 checksum of the normalized
+// SyncML body, used to detect that a profile's content didn't actually
+// change across a gitops apply so that hosts aren't needlessly redelivered
+// an identical profile.
+func mdmWindowsProfileChecksum(syncml []byte) []byte {
+	normalized := bytes.TrimSpace(syncml)
+	sum := sha256.Sum256(normalized)
+	return sum[:]
+}
+
 func (ds *Datastore) NewMDMWindowsConfigProfile(ctx context.Context, cp fleet.MDMWindowsConfigProfile) (*fleet.MDMWindowsConfigProfile, error) {
 	profileUUID := uuid.New().String()
+	checksum := mdmWindowsProfileChecksum(cp.SyncML)
 	stmt := `
 INSERT INTO
-    mdm_windows_configuration_profiles (profile_uuid, team_id, name, syncml)
-(SELECT ?, ?, ?, ? FROM DUAL WHERE
+    mdm_windows_configuration_profiles (profile_uuid, team_id, name, syncml, checksum)
+(SELECT ?, ?, ?, ?, ? FROM DUAL WHERE
 	NOT EXISTS (
 		SELECT 1 FROM mdm_apple_configuration_profiles WHERE name = ? AND team_id = ?
 	)
@@ -1327,7 +1828,7 @@ INSERT INTO
 		teamID = *cp.TeamID
 	}
 
-	res, err := ds.writer(ctx).ExecContext(ctx, stmt, profileUUID, teamID, cp.Name, cp.SyncML, cp.Name, teamID)
+	res, err := ds.writer(ctx).ExecContext(ctx, stmt, profileUUID, teamID, cp.Name, cp.SyncML, checksum, cp.Name, teamID)
 	if err != nil {
 		switch {
 		case isDuplicate(err):
@@ -1367,7 +1868,8 @@ func (ds *Datastore) batchSetMDMWindowsProfilesDB(
 	const loadExistingProfiles = `
 SELECT
   name,
-  syncml
+  syncml,
+  checksum
 FROM
   mdm_windows_configuration_profiles
 WHERE
@@ -1390,16 +1892,27 @@ WHERE
   team_id = ?
 `
 
+	// syncml and updated_at are only overwritten when the incoming checksum
+	// differs from the one already stored, so that applying a profile set
+	// where only unrelated profiles changed doesn't touch the unchanged
+	// ones (and therefore doesn't trigger a redelivery to every host with
+	// that profile, see listMDMWindowsProfilesToInstallDB). checksum IS
+	// NULL is treated as "changed" too: rows from before checksum existed
+	// have it NULL with no backfill, and checksum != VALUES(checksum)
+	// evaluates to NULL (never true) against a NULL column, which would
+	// silently keep the stale syncml on the first edit after migrating.
 	const insertNewOrEditedProfile = `
 INSERT INTO
   mdm_windows_configuration_profiles (
-    profile_uuid, team_id, name, syncml
+    profile_uuid, team_id, name, syncml, checksum
   )
 VALUES
-  ( UUID(), ?, ?, ? )
+  ( UUID(), ?, ?, ?, ? )
 ON DUPLICATE KEY UPDATE
   name = VALUES(name),
-  syncml = VALUES(syncml)
+  syncml = IF(checksum IS NULL OR checksum != VALUES(checksum), VALUES(syncml), syncml),
+  updated_at = IF(checksum IS NULL OR checksum != VALUES(checksum), CURRENT_TIMESTAMP, updated_at),
+  checksum = VALUES(checksum)
 `
 
 	// use a profile team id of 0 if no-team
@@ -1463,7 +1976,8 @@ ON DUPLICATE KEY UPDATE
 
 		// insert the new profiles and the ones that have changed
 		for _, p := range incomingProfs {
-			if _, err := tx.ExecContext(ctx, insertNewOrEditedProfile, profTeamID, p.Name, p.SyncML); err != nil {
+			checksum := mdmWindowsProfileChecksum(p.SyncML)
+			if _, err := tx.ExecContext(ctx, insertNewOrEditedProfile, profTeamID, p.Name, p.SyncML, checksum); err != nil {
 				return ctxerr.Wrapf(ctx, err, "insert new/edited profile with name %q", p.Name)
 			}
 		}
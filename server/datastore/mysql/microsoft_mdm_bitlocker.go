@@ -0,0 +1,193 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// BitLocker predicate fragments shared by every BitLockerStatusEvaluator
+// implementation; only the grace-period fragment varies between them.
+const (
+	whereNotServer        = `(hmdm.is_server IS NOT NULL AND hmdm.is_server = 0)`
+	whereKeyAvailable     = `(hdek.base64_encrypted IS NOT NULL AND hdek.base64_encrypted != '' AND hdek.decryptable IS NOT NULL AND hdek.decryptable = 1)`
+	whereEncrypted        = `(hd.encrypted IS NOT NULL AND hd.encrypted = 1)`
+	whereHostDisksUpdated = `(hd.updated_at IS NOT NULL AND hdek.updated_at IS NOT NULL AND hd.updated_at >= hdek.updated_at)`
+	whereClientError      = `(hdek.client_error IS NOT NULL AND hdek.client_error != '')`
+)
+
+// BitLockerStatusEvaluator produces the predicate used to classify a host's
+// BitLocker disk encryption status. defaultBitLockerStatusEvaluator
+// implements this against the current SQL state machine; GoBitLockerStatusEvaluator
+// implements the same rules so they can also be evaluated directly in Go,
+// without a database, via its additional Evaluate method.
+type BitLockerStatusEvaluator interface {
+	// WhereClause returns a SQL predicate suitable for inclusion in a WHERE
+	// clause that joins host_disk_encryption_keys (as hdek), host_mdm (as
+	// hmdm) and host_disks (as hd), see whereBitLockerStatus for the full
+	// contract.
+	WhereClause(status fleet.DiskEncryptionStatus) string
+}
+
+// defaultBitLockerStatusEvaluator is the evaluator Datastore uses unless a
+// caller registers a different one via SetBitLockerStatusEvaluator. It
+// produces the exact SQL fragments historically hardcoded in
+// whereBitLockerStatus.
+type defaultBitLockerStatusEvaluator struct {
+	logger log.Logger
+}
+
+func (e defaultBitLockerStatusEvaluator) WhereClause(status fleet.DiskEncryptionStatus) string {
+	const withinGracePeriod = `(hdek.updated_at IS NOT NULL AND hdek.updated_at >= DATE_SUB(NOW(), INTERVAL 1 HOUR))`
+
+	clause, ok := bitLockerWhereClause(status, withinGracePeriod)
+	if !ok {
+		level.Debug(e.logger).Log("msg", "unknown bitlocker status", "status", status)
+		return "FALSE"
+	}
+	return clause
+}
+
+// bitLockerWhereClause builds the SQL predicate for status given a
+// withinGracePeriod fragment, which is the only part of the rules that
+// varies between evaluator implementations. ok is false for an unrecognized
+// status, in which case callers should fall back to a safe default (e.g.
+// "FALSE").
+//
+// TODO: what if windows sends us a key for an already encrypted volumne? could it get stuck
+// in pending or verifying? should we modify SetOrUpdateHostDiskEncryption to ensure that we
+// increment the updated_at timestamp on the host_disks table for all encrypted volumes
+// host_disks if the hdek timestamp is newer? What about SetOrUpdateHostDiskEncryptionKey?
+func bitLockerWhereClause(status fleet.DiskEncryptionStatus, withinGracePeriod string) (string, bool) {
+	switch status {
+	case fleet.DiskEncryptionVerified:
+		return whereNotServer + `
+AND NOT ` + whereClientError + `
+AND ` + whereKeyAvailable + `
+AND ` + whereEncrypted + `
+AND ` + whereHostDisksUpdated, true
+
+	case fleet.DiskEncryptionVerifying:
+		// Possible verifying scenarios:
+		// - we have the key and host_disks already encrypted before the key but hasn't been updated yet
+		// - we have the key and host_disks reported unencrypted during the grace period after key was updated
+		return whereNotServer + `
+AND NOT ` + whereClientError + `
+AND ` + whereKeyAvailable + `
+AND (
+    (` + whereEncrypted + ` AND NOT ` + whereHostDisksUpdated + `)
+    OR (NOT ` + whereEncrypted + ` AND ` + whereHostDisksUpdated + ` AND ` + withinGracePeriod + `)
+)`, true
+
+	case fleet.DiskEncryptionEnforcing:
+		// Possible enforcing scenarios:
+		// - we don't have the key
+		// - we have the key and host_disks reported unencrypted before the key was updated or outside the grace period after key was updated
+		return whereNotServer + `
+AND NOT ` + whereClientError + `
+AND (
+    NOT ` + whereKeyAvailable + `
+    OR (` + whereKeyAvailable + `
+        AND (NOT ` + whereEncrypted + `
+            AND (NOT ` + whereHostDisksUpdated + ` OR NOT ` + withinGracePeriod + `)
+		)
+	)
+)`, true
+
+	case fleet.DiskEncryptionFailed:
+		return whereNotServer + ` AND ` + whereClientError, true
+
+	default:
+		return "", false
+	}
+}
+
+// GoBitLockerStatusEvaluator mirrors the rules in defaultBitLockerStatusEvaluator
+// in Go so that state-transition logic can be unit-tested without a
+// database via Evaluate, which operates directly on a
+// fleet.HostDiskEncryptionState. It also implements BitLockerStatusEvaluator,
+// so it can be registered with SetBitLockerStatusEvaluator when a deployment
+// wants its custom GracePeriod reflected in the SQL predicates too, not just
+// in Evaluate.
+type GoBitLockerStatusEvaluator struct {
+	// GracePeriod mirrors the 1-hour grace period baked into the SQL
+	// evaluator, during which a host that reports unencrypted disks after a
+	// key rotation is still considered "verifying" rather than "enforcing".
+	GracePeriod time.Duration
+}
+
+// NewGoBitLockerStatusEvaluator returns a GoBitLockerStatusEvaluator with the
+// same default grace period as the SQL-based evaluator.
+func NewGoBitLockerStatusEvaluator() *GoBitLockerStatusEvaluator {
+	return &GoBitLockerStatusEvaluator{GracePeriod: time.Hour}
+}
+
+// Evaluate computes the DiskEncryptionStatus for state using the same rules
+// as defaultBitLockerStatusEvaluator's SQL predicates.
+func (e *GoBitLockerStatusEvaluator) Evaluate(state fleet.HostDiskEncryptionState) fleet.DiskEncryptionStatus {
+	if state.IsServer {
+		return ""
+	}
+
+	withinGracePeriod := !state.KeyUpdatedAt.IsZero() && time.Since(state.KeyUpdatedAt) <= e.GracePeriod
+	hostDisksUpdated := !state.DisksUpdatedAt.IsZero() && !state.KeyUpdatedAt.IsZero() && !state.DisksUpdatedAt.Before(state.KeyUpdatedAt)
+
+	switch {
+	case state.ClientError != "":
+		return fleet.DiskEncryptionFailed
+	case !state.KeyAvailable:
+		return fleet.DiskEncryptionEnforcing
+	case state.Encrypted && hostDisksUpdated:
+		return fleet.DiskEncryptionVerified
+	case state.Encrypted && !hostDisksUpdated:
+		return fleet.DiskEncryptionVerifying
+	case !state.Encrypted && hostDisksUpdated && withinGracePeriod:
+		return fleet.DiskEncryptionVerifying
+	default:
+		return fleet.DiskEncryptionEnforcing
+	}
+}
+
+// WhereClause satisfies BitLockerStatusEvaluator, building the same SQL
+// predicate as defaultBitLockerStatusEvaluator.WhereClause but with the
+// grace-period window taken from e.GracePeriod instead of being hardcoded to
+// one hour.
+func (e *GoBitLockerStatusEvaluator) WhereClause(status fleet.DiskEncryptionStatus) string {
+	withinGracePeriod := fmt.Sprintf(
+		`(hdek.updated_at IS NOT NULL AND hdek.updated_at >= DATE_SUB(NOW(), INTERVAL %d SECOND))`,
+		int64(e.GracePeriod/time.Second),
+	)
+
+	clause, ok := bitLockerWhereClause(status, withinGracePeriod)
+	if !ok {
+		return "FALSE"
+	}
+	return clause
+}
+
+// RecordBitLockerStatusChange inserts an audit row recording that hostID's
+// BitLocker status moved from previous to current, so admins can see when
+// and why a host transitioned instead of only seeing the current snapshot.
+func (ds *Datastore) RecordBitLockerStatusChange(ctx context.Context, hostID uint, previous, current fleet.DiskEncryptionStatus, detail string) error {
+	stmt := `
+INSERT INTO host_disk_encryption_status_changes
+    (host_id, previous_status, current_status, detail)
+VALUES
+    (?, ?, ?, ?)`
+
+	if _, err := ds.writer(ctx).ExecContext(ctx, stmt, hostID, previous, current, detail); err != nil {
+		return ctxerr.Wrap(ctx, err, "recording bitlocker status change")
+	}
+	return nil
+}
+
+// ensure both evaluators satisfy BitLockerStatusEvaluator.
+var (
+	_ BitLockerStatusEvaluator = defaultBitLockerStatusEvaluator{}
+	_ BitLockerStatusEvaluator = &GoBitLockerStatusEvaluator{}
+)
@@ -0,0 +1,96 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoBitLockerStatusEvaluatorEvaluate(t *testing.T) {
+	now := time.Now()
+	evaluator := &GoBitLockerStatusEvaluator{GracePeriod: time.Hour}
+
+	cases := []struct {
+		name  string
+		state fleet.HostDiskEncryptionState
+		want  fleet.DiskEncryptionStatus
+	}{
+		{
+			name:  "server host has no status",
+			state: fleet.HostDiskEncryptionState{IsServer: true},
+			want:  "",
+		},
+		{
+			name:  "client error always wins",
+			state: fleet.HostDiskEncryptionState{ClientError: "boom", KeyAvailable: true, Encrypted: true},
+			want:  fleet.DiskEncryptionFailed,
+		},
+		{
+			name:  "no key available is enforcing",
+			state: fleet.HostDiskEncryptionState{KeyAvailable: false},
+			want:  fleet.DiskEncryptionEnforcing,
+		},
+		{
+			name: "encrypted after key recorded is verified",
+			state: fleet.HostDiskEncryptionState{
+				KeyAvailable:   true,
+				Encrypted:      true,
+				KeyUpdatedAt:   now.Add(-2 * time.Hour),
+				DisksUpdatedAt: now,
+			},
+			want: fleet.DiskEncryptionVerified,
+		},
+		{
+			name: "encrypted before key was updated is verifying",
+			state: fleet.HostDiskEncryptionState{
+				KeyAvailable:   true,
+				Encrypted:      true,
+				KeyUpdatedAt:   now,
+				DisksUpdatedAt: now.Add(-time.Hour),
+			},
+			want: fleet.DiskEncryptionVerifying,
+		},
+		{
+			name: "unencrypted within grace period is verifying",
+			state: fleet.HostDiskEncryptionState{
+				KeyAvailable:   true,
+				Encrypted:      false,
+				KeyUpdatedAt:   now.Add(-30 * time.Minute),
+				DisksUpdatedAt: now,
+			},
+			want: fleet.DiskEncryptionVerifying,
+		},
+		{
+			name: "unencrypted outside grace period is enforcing",
+			state: fleet.HostDiskEncryptionState{
+				KeyAvailable:   true,
+				Encrypted:      false,
+				KeyUpdatedAt:   now.Add(-2 * time.Hour),
+				DisksUpdatedAt: now,
+			},
+			want: fleet.DiskEncryptionEnforcing,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, evaluator.Evaluate(c.state))
+		})
+	}
+}
+
+func TestGoBitLockerStatusEvaluatorWhereClauseUsesConfiguredGracePeriod(t *testing.T) {
+	shortGrace := &GoBitLockerStatusEvaluator{GracePeriod: 15 * time.Minute}
+	longGrace := &GoBitLockerStatusEvaluator{GracePeriod: 3 * time.Hour}
+
+	shortClause := shortGrace.WhereClause(fleet.DiskEncryptionVerifying)
+	longClause := longGrace.WhereClause(fleet.DiskEncryptionVerifying)
+
+	assert.Contains(t, shortClause, "INTERVAL 900 SECOND")
+	assert.Contains(t, longClause, "INTERVAL 10800 SECOND")
+	assert.NotEqual(t, shortClause, longClause)
+
+	assert.Equal(t, "FALSE", shortGrace.WhereClause(fleet.DiskEncryptionStatus("bogus")))
+}
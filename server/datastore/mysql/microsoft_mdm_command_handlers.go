@@ -0,0 +1,79 @@
+package mysql
+
+import (
+	"context"
+	"sync"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+// ResponseHandler processes a single <Status> element of a device's SyncML
+// response against the MDMWindowsCommand it refers to. <Results> elements
+// are not dispatched to handlers.
+// statusesByCmdRef carries every <Status> seen in the same response, keyed
+// by command reference, in case a handler needs to cross-reference more
+// than its own command (as the default Atomic handler does to recover a
+// profile payload's detail/status).
+//
+// A handler runs inside the same transaction as the rest of
+// MDMWindowsSaveResponse; it may return a non-nil *fleet.MDMWindowsProfilePayload
+// to have the caller fold a profile status update into the batched
+// host_mdm_windows_profiles update, or nil if it doesn't affect profile
+// status (e.g. a WNS push acknowledgment, or an MSI install progress event).
+type ResponseHandler func(
+	ctx context.Context,
+	tx sqlx.ExtContext,
+	enrollment *fleet.MDMWindowsEnrolledDevice,
+	cmd fleet.MDMWindowsCommand,
+	statusesByCmdRef map[string]fleet.SyncMLCmd,
+) (*fleet.MDMWindowsProfilePayload, error)
+
+type mdmWindowsCommandHandlerKey struct {
+	verb   fleet.SyncMLVerb
+	opType fleet.SyncMLCmdType
+}
+
+var (
+	mdmWindowsCommandHandlersMu sync.RWMutex
+	mdmWindowsCommandHandlers   = make(map[mdmWindowsCommandHandlerKey]ResponseHandler)
+)
+
+// RegisterMDMWindowsCommandHandler registers h to process responses for
+// SyncML commands of type opType (Atomic, Replace, Exec, Add, Delete, ...)
+// carried in a <Status> or <Results> element identified by verb. Registering
+// a handler for a (verb, opType) pair that is already registered replaces the
+// previous handler. This lets new MDM features (WNS pushes, MSI install
+// tracking, Wipe/Lock lifecycle) plug into MDMWindowsSaveResponse without
+// editing it. MDMWindowsSaveResponse only looks up handlers with verb
+// fleet.CmdStatus, keyed by the Cmd named in the <Status>; a handler
+// registered under any other verb is stored but never invoked by it.
+func RegisterMDMWindowsCommandHandler(verb fleet.SyncMLVerb, opType fleet.SyncMLCmdType, h ResponseHandler) {
+	mdmWindowsCommandHandlersMu.Lock()
+	defer mdmWindowsCommandHandlersMu.Unlock()
+	mdmWindowsCommandHandlers[mdmWindowsCommandHandlerKey{verb: verb, opType: opType}] = h
+}
+
+func lookupMDMWindowsCommandHandler(verb fleet.SyncMLVerb, opType fleet.SyncMLCmdType) ResponseHandler {
+	mdmWindowsCommandHandlersMu.RLock()
+	defer mdmWindowsCommandHandlersMu.RUnlock()
+	return mdmWindowsCommandHandlers[mdmWindowsCommandHandlerKey{verb: verb, opType: opType}]
+}
+
+func init() {
+	// The only built-in handler today: a <Status> for an Atomic command may
+	// carry a profile install/remove result, so fold it into a
+	// MDMWindowsProfilePayload the same way MDMWindowsSaveResponse always
+	// has.
+	RegisterMDMWindowsCommandHandler(fleet.CmdStatus, fleet.CmdAtomic, handleAtomicStatusResponse)
+}
+
+func handleAtomicStatusResponse(
+	_ context.Context,
+	_ sqlx.ExtContext,
+	enrollment *fleet.MDMWindowsEnrolledDevice,
+	cmd fleet.MDMWindowsCommand,
+	statusesByCmdRef map[string]fleet.SyncMLCmd,
+) (*fleet.MDMWindowsProfilePayload, error) {
+	return fleet.BuildMDMWindowsProfilePayloadFromMDMResponse(cmd, statusesByCmdRef, enrollment.HostUUID)
+}
@@ -0,0 +1,68 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDMWindowsCommandHandlerRegistry(t *testing.T) {
+	const verb = fleet.CmdStatus
+	const opType = fleet.SyncMLCmdType("TestOp")
+
+	t.Cleanup(func() {
+		mdmWindowsCommandHandlersMu.Lock()
+		delete(mdmWindowsCommandHandlers, mdmWindowsCommandHandlerKey{verb: verb, opType: opType})
+		mdmWindowsCommandHandlersMu.Unlock()
+	})
+
+	assert.Nil(t, lookupMDMWindowsCommandHandler(verb, opType), "unregistered (verb, opType) pair should have no handler")
+
+	var calls int
+	first := func(
+		_ context.Context,
+		_ sqlx.ExtContext,
+		_ *fleet.MDMWindowsEnrolledDevice,
+		_ fleet.MDMWindowsCommand,
+		_ map[string]fleet.SyncMLCmd,
+	) (*fleet.MDMWindowsProfilePayload, error) {
+		calls++
+		return nil, nil
+	}
+	RegisterMDMWindowsCommandHandler(verb, opType, first)
+
+	h := lookupMDMWindowsCommandHandler(verb, opType)
+	require.NotNil(t, h)
+	_, err := h(context.Background(), nil, &fleet.MDMWindowsEnrolledDevice{}, fleet.MDMWindowsCommand{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	// registering again for the same (verb, opType) replaces the handler
+	// rather than stacking it.
+	second := func(
+		_ context.Context,
+		_ sqlx.ExtContext,
+		_ *fleet.MDMWindowsEnrolledDevice,
+		_ fleet.MDMWindowsCommand,
+		_ map[string]fleet.SyncMLCmd,
+	) (*fleet.MDMWindowsProfilePayload, error) {
+		return &fleet.MDMWindowsProfilePayload{}, nil
+	}
+	RegisterMDMWindowsCommandHandler(verb, opType, second)
+
+	h = lookupMDMWindowsCommandHandler(verb, opType)
+	require.NotNil(t, h)
+	payload, err := h(context.Background(), nil, &fleet.MDMWindowsEnrolledDevice{}, fleet.MDMWindowsCommand{}, nil)
+	require.NoError(t, err)
+	assert.NotNil(t, payload)
+	assert.Equal(t, 1, calls, "replaced handler should not be invoked")
+}
+
+func TestBuiltInAtomicStatusHandlerIsRegistered(t *testing.T) {
+	assert.NotNil(t, lookupMDMWindowsCommandHandler(fleet.CmdStatus, fleet.CmdAtomic),
+		"init() should register the default Atomic status handler")
+}
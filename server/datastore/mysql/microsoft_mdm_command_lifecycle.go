@@ -0,0 +1,220 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+// MDMWindowsSetCommandTTL sets the time-to-live for cmdUUID: if the device
+// has not acknowledged it within ttl of its creation, it becomes eligible
+// for MDMWindowsSweepExpiredCommands to dead-letter.
+func (ds *Datastore) MDMWindowsSetCommandTTL(ctx context.Context, cmdUUID string, ttl time.Duration) error {
+	stmt := `UPDATE windows_mdm_commands SET ttl_seconds = ? WHERE command_uuid = ?`
+
+	res, err := ds.writer(ctx).ExecContext(ctx, stmt, uint(ttl.Seconds()), cmdUUID)
+	if err != nil {
+		return ctxerr.Wrap(ctx, err, "setting windows mdm command ttl")
+	}
+	affected, _ := res.RowsAffected()
+	if affected == 0 {
+		return ctxerr.Wrap(ctx, notFound("MDMWindowsCommand").WithName(cmdUUID))
+	}
+	return nil
+}
+
+// MDMWindowsSweepExpiredCommands moves commands whose TTL has elapsed as of
+// now from the active queue to the dead-letter table, and marks the
+// host_mdm_windows_profiles row for each expired (host, command) pair as
+// MDMDeliveryFailed with a synthesized detail. A command_uuid may be queued
+// for many hosts, so only the hosts that actually had it expire are marked
+// failed; hosts that already acked or verified it are left untouched. It
+// returns the number of expired queue entries, i.e. (host, command) pairs,
+// so a single command that expired for three hosts counts as three.
+//
+// NOTE: this only implements the sweep itself. Registering it to run
+// periodically is done by the cron package, which isn't part of this
+// datastore package and needs to call this on its own schedule.
+func (ds *Datastore) MDMWindowsSweepExpiredCommands(ctx context.Context, now time.Time) (int, error) {
+	const findExpiredStmt = `
+SELECT
+    wmcq.enrollment_id,
+    wmcq.command_uuid,
+    mwe.host_uuid,
+    wmc.raw_command,
+    wmc.target_loc_uri,
+    wmc.created_at
+FROM
+    windows_mdm_command_queue wmcq
+INNER JOIN
+    windows_mdm_commands wmc ON wmc.command_uuid = wmcq.command_uuid
+INNER JOIN
+    mdm_windows_enrollments mwe ON mwe.id = wmcq.enrollment_id
+WHERE
+    wmc.ttl_seconds IS NOT NULL
+    AND wmc.created_at + INTERVAL wmc.ttl_seconds SECOND < ?`
+
+	const findLastStatusStmt = `
+SELECT status_code FROM windows_mdm_command_results
+WHERE enrollment_id = ? AND command_uuid = ?
+ORDER BY updated_at DESC LIMIT 1`
+
+	const insertDeadLetterStmt = `
+INSERT INTO windows_mdm_command_dead_letter
+    (command_uuid, enrollment_id, host_uuid, raw_command, target_loc_uri, last_status, original_created_at)
+VALUES %s`
+
+	const dequeueStmt = `DELETE FROM windows_mdm_command_queue WHERE command_uuid IN (?)`
+
+	const failProfilesStmtFmt = `
+UPDATE host_mdm_windows_profiles
+SET status = ?, detail = ?
+WHERE (host_uuid, command_uuid) IN (%s)`
+
+	var expired int
+	err := ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		var rows []struct {
+			EnrollmentID uint      `db:"enrollment_id"`
+			CommandUUID  string    `db:"command_uuid"`
+			HostUUID     string    `db:"host_uuid"`
+			RawCommand   []byte    `db:"raw_command"`
+			TargetLocURI string    `db:"target_loc_uri"`
+			CreatedAt    time.Time `db:"created_at"`
+		}
+		if err := sqlx.SelectContext(ctx, tx, &rows, findExpiredStmt, now); err != nil {
+			return ctxerr.Wrap(ctx, err, "finding expired windows mdm commands")
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		var args []any
+		var sb strings.Builder
+		var cmdUUIDs []string
+		var failArgs []any
+		var failSb strings.Builder
+		for _, r := range rows {
+			var lastStatus string
+			if err := sqlx.GetContext(ctx, tx, &lastStatus, findLastStatusStmt, r.EnrollmentID, r.CommandUUID); err != nil && err != sql.ErrNoRows {
+				return ctxerr.Wrap(ctx, err, "finding last status for expired command")
+			}
+
+			args = append(args, r.CommandUUID, r.EnrollmentID, r.HostUUID, r.RawCommand, r.TargetLocURI, lastStatus, r.CreatedAt)
+			sb.WriteString("(?, ?, ?, ?, ?, ?, ?),")
+			cmdUUIDs = append(cmdUUIDs, r.CommandUUID)
+
+			failArgs = append(failArgs, r.HostUUID, r.CommandUUID)
+			failSb.WriteString("(?, ?),")
+		}
+
+		stmt := fmt.Sprintf(insertDeadLetterStmt, strings.TrimSuffix(sb.String(), ","))
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "inserting windows mdm dead letter commands")
+		}
+
+		dequeue, dargs, err := sqlx.In(dequeueStmt, cmdUUIDs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "building sqlx.In to dequeue expired commands")
+		}
+		if _, err := tx.ExecContext(ctx, dequeue, dargs...); err != nil {
+			return ctxerr.Wrap(ctx, err, "dequeuing expired windows mdm commands")
+		}
+
+		detail := "The MDM command was not acknowledged by the host before its TTL elapsed."
+		failStmt := fmt.Sprintf(failProfilesStmtFmt, strings.TrimSuffix(failSb.String(), ","))
+		fargs := append([]any{fleet.MDMDeliveryFailed, detail}, failArgs...)
+		if _, err := tx.ExecContext(ctx, failStmt, fargs...); err != nil {
+			return ctxerr.Wrap(ctx, err, "marking host profiles failed for expired commands")
+		}
+
+		expired = len(rows)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
+
+// MDMWindowsListDeadLetteredCommands returns dead-lettered commands matching
+// filter, most recently dead-lettered first.
+func (ds *Datastore) MDMWindowsListDeadLetteredCommands(ctx context.Context, filter fleet.MDMWindowsDeadLetterFilter) ([]*fleet.MDMWindowsDeadLetterCommand, error) {
+	stmt := `
+SELECT
+    id,
+    command_uuid,
+    host_uuid,
+    target_loc_uri,
+    last_status,
+    original_created_at,
+    dead_lettered_at
+FROM
+    windows_mdm_command_dead_letter
+WHERE
+    (? = '' OR host_uuid = ?)
+ORDER BY
+    dead_lettered_at DESC`
+
+	var commands []*fleet.MDMWindowsDeadLetterCommand
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &commands, stmt, filter.HostUUID, filter.HostUUID); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "listing windows mdm dead lettered commands")
+	}
+	return commands, nil
+}
+
+// MDMWindowsRequeueDeadLetter re-enqueues the dead-lettered command cmdUUID
+// for delivery to hostUUIDs and removes the corresponding dead-letter rows.
+func (ds *Datastore) MDMWindowsRequeueDeadLetter(ctx context.Context, cmdUUID string, hostUUIDs []string) error {
+	if len(hostUUIDs) == 0 {
+		return nil
+	}
+
+	const getDeadLetterStmt = `
+SELECT raw_command, target_loc_uri FROM windows_mdm_command_dead_letter
+WHERE command_uuid = ? LIMIT 1`
+
+	const deleteDeadLetterStmt = `
+DELETE FROM windows_mdm_command_dead_letter
+WHERE command_uuid = ? AND host_uuid IN (?)`
+
+	return ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		var dl struct {
+			RawCommand   []byte `db:"raw_command"`
+			TargetLocURI string `db:"target_loc_uri"`
+		}
+		if err := sqlx.GetContext(ctx, tx, &dl, getDeadLetterStmt, cmdUUID); err != nil {
+			if err == sql.ErrNoRows {
+				return ctxerr.Wrap(ctx, notFound("MDMWindowsDeadLetterCommand").WithName(cmdUUID))
+			}
+			return ctxerr.Wrap(ctx, err, "loading dead lettered command")
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO windows_mdm_commands (command_uuid, raw_command, target_loc_uri)
+VALUES (?, ?, ?)
+ON DUPLICATE KEY UPDATE raw_command = VALUES(raw_command)`, cmdUUID, dl.RawCommand, dl.TargetLocURI); err != nil {
+			return ctxerr.Wrap(ctx, err, "re-creating requeued windows mdm command")
+		}
+
+		for _, hostUUID := range hostUUIDs {
+			if err := ds.mdmWindowsInsertHostCommandDB(ctx, tx, hostUUID, cmdUUID); err != nil {
+				return err
+			}
+		}
+
+		stmt, args, err := sqlx.In(deleteDeadLetterStmt, cmdUUID, hostUUIDs)
+		if err != nil {
+			return ctxerr.Wrap(ctx, err, "building sqlx.In to clear dead letter rows")
+		}
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return ctxerr.Wrap(ctx, err, "clearing requeued dead letter rows")
+		}
+		return nil
+	})
+}
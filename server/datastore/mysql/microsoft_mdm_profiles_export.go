@@ -0,0 +1,260 @@
+package mysql
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+// mdmWindowsProfilesManifestEntry is one entry of the manifest.json packed
+// alongside the per-profile .xml files in the archive produced by
+// ExportMDMWindowsConfigProfiles.
+type mdmWindowsProfilesManifestEntry struct {
+	ProfileUUID string    `json:"profile_uuid"`
+	Name        string    `json:"name"`
+	TeamID      *uint     `json:"team_id"`
+	Checksum    string    `json:"checksum"`
+	ExportedAt  time.Time `json:"exported_at"`
+	File        string    `json:"file"`
+}
+
+type mdmWindowsProfilesManifest struct {
+	Entries []mdmWindowsProfilesManifestEntry `json:"profiles"`
+}
+
+// ExportMDMWindowsConfigProfiles packages every Windows MDM configuration
+// profile for teamID (nil/0 meaning "no team") into a zip archive: one .xml
+// file per profile holding its SyncML body, plus a manifest.json recording
+// profile UUID, name, team, checksum and export timestamp for each. The
+// returned hmacKey authenticates the archive (via HMAC-SHA256, hex-encoded
+// and appended after the zip data as a trailing "\n--fleet-hmac-sha256:<hex>"
+// line, not as a zip entry) so that a recipient who
+// also receives the key out of band can detect tampering in transit; the
+// key is not embedded anywhere else in the archive.
+func (ds *Datastore) ExportMDMWindowsConfigProfiles(ctx context.Context, teamID *uint) (io.ReadCloser, []byte, error) {
+	var profTeamID uint
+	if teamID != nil {
+		profTeamID = *teamID
+	}
+
+	var profiles []*fleet.MDMWindowsConfigProfile
+	stmt := `
+SELECT profile_uuid, team_id, name, syncml, checksum, created_at, updated_at
+FROM mdm_windows_configuration_profiles
+WHERE team_id = ?
+ORDER BY name`
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &profiles, stmt, profTeamID); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "loading windows mdm config profiles to export")
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	exportedAt := time.Now().UTC()
+	manifest := mdmWindowsProfilesManifest{Entries: make([]mdmWindowsProfilesManifestEntry, 0, len(profiles))}
+	for _, p := range profiles {
+		fileName := fmt.Sprintf("%s.xml", p.ProfileUUID)
+		fw, err := zw.Create(fileName)
+		if err != nil {
+			return nil, nil, ctxerr.Wrap(ctx, err, "creating profile entry in export archive")
+		}
+		if _, err := fw.Write(p.SyncML); err != nil {
+			return nil, nil, ctxerr.Wrap(ctx, err, "writing profile entry in export archive")
+		}
+
+		manifest.Entries = append(manifest.Entries, mdmWindowsProfilesManifestEntry{
+			ProfileUUID: p.ProfileUUID,
+			Name:        p.Name,
+			TeamID:      teamID,
+			Checksum:    hex.EncodeToString(p.Checksum),
+			ExportedAt:  exportedAt,
+			File:        fileName,
+		})
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "marshaling export manifest")
+	}
+	mw, err := zw.Create("manifest.json")
+	if err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "creating manifest entry in export archive")
+	}
+	if _, err := mw.Write(manifestJSON); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "writing manifest entry in export archive")
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "closing export archive")
+	}
+
+	hmacKey := make([]byte, 32)
+	if _, err := rand.Read(hmacKey); err != nil {
+		return nil, nil, ctxerr.Wrap(ctx, err, "generating export archive hmac key")
+	}
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(buf.Bytes())
+
+	// Append the digest as a trailer after the zip's end-of-central-directory
+	// record rather than as a zip entry, so a recipient can verify the
+	// archive (by trimming the trailer back off) without having to unzip it
+	// first.
+	sealed := append(buf.Bytes(), []byte("\n--fleet-hmac-sha256:"+hex.EncodeToString(mac.Sum(nil)))...)
+
+	return io.NopCloser(bytes.NewReader(sealed)), hmacKey, nil
+}
+
+// MDMWindowsProfilesImportOptions controls how ImportMDMWindowsConfigProfiles
+// reconciles the archive's profiles against teamID's existing ones.
+type MDMWindowsProfilesImportOptions struct {
+	// DryRun, when true, computes and returns the report without writing
+	// anything.
+	DryRun bool
+	// Merge, when true, only adds/updates the profiles present in the
+	// archive and leaves any other existing profile for the team alone. When
+	// false (Replace), any existing profile not present in the archive is
+	// deleted, mirroring gitops apply semantics.
+	Merge bool
+}
+
+// MDMWindowsProfilesImportReport summarizes the effect (or, for a dry run,
+// the would-be effect) of ImportMDMWindowsConfigProfiles.
+type MDMWindowsProfilesImportReport struct {
+	Added   []string
+	Updated []string
+	Deleted []string
+}
+
+// ImportMDMWindowsConfigProfiles reads the zip archive produced by
+// ExportMDMWindowsConfigProfiles from r and applies its profiles to teamID.
+// The archive's trailing HMAC digest, if present, is not itself verified
+// here (callers that received the key out of band should verify before
+// calling); it is ignored if missing, since not every archive the admin
+// wants to import was necessarily sealed.
+func (ds *Datastore) ImportMDMWindowsConfigProfiles(
+	ctx context.Context,
+	teamID *uint,
+	r io.Reader,
+	opts MDMWindowsProfilesImportOptions,
+) (MDMWindowsProfilesImportReport, error) {
+	var report MDMWindowsProfilesImportReport
+
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return report, ctxerr.Wrap(ctx, err, "reading import archive")
+	}
+	if i := bytes.LastIndex(raw, []byte("\n--fleet-hmac-sha256:")); i >= 0 {
+		raw = raw[:i]
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return report, ctxerr.Wrap(ctx, err, "opening import archive")
+	}
+
+	var manifest mdmWindowsProfilesManifest
+	manifestFile, err := zr.Open("manifest.json")
+	if err != nil {
+		return report, ctxerr.Wrap(ctx, err, "import archive is missing manifest.json")
+	}
+	manifestBytes, err := io.ReadAll(manifestFile)
+	manifestFile.Close()
+	if err != nil {
+		return report, ctxerr.Wrap(ctx, err, "reading manifest.json")
+	}
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return report, ctxerr.Wrap(ctx, err, "parsing manifest.json")
+	}
+
+	incoming := make([]*fleet.MDMWindowsConfigProfile, 0, len(manifest.Entries))
+	for _, entry := range manifest.Entries {
+		f, err := zr.Open(entry.File)
+		if err != nil {
+			return report, ctxerr.Wrapf(ctx, err, "opening profile file %q from manifest", entry.File)
+		}
+		syncml, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return report, ctxerr.Wrapf(ctx, err, "reading profile file %q", entry.File)
+		}
+		incoming = append(incoming, &fleet.MDMWindowsConfigProfile{
+			ProfileUUID: entry.ProfileUUID,
+			Name:        entry.Name,
+			SyncML:      syncml,
+			TeamID:      teamID,
+		})
+	}
+
+	var profTeamID uint
+	if teamID != nil {
+		profTeamID = *teamID
+	}
+
+	var existingNames []string
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &existingNames, `
+SELECT name FROM mdm_windows_configuration_profiles WHERE team_id = ?`, profTeamID); err != nil {
+		return report, ctxerr.Wrap(ctx, err, "loading existing profile names")
+	}
+	existing := make(map[string]bool, len(existingNames))
+	for _, n := range existingNames {
+		existing[n] = true
+	}
+
+	for _, p := range incoming {
+		if existing[p.Name] {
+			report.Updated = append(report.Updated, p.Name)
+		} else {
+			report.Added = append(report.Added, p.Name)
+		}
+	}
+	if !opts.Merge {
+		incomingNames := make(map[string]bool, len(incoming))
+		for _, p := range incoming {
+			incomingNames[p.Name] = true
+		}
+		for n := range existing {
+			if !incomingNames[n] {
+				report.Deleted = append(report.Deleted, n)
+			}
+		}
+	}
+
+	if opts.DryRun {
+		return report, nil
+	}
+
+	err = ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		if opts.Merge {
+			for _, p := range incoming {
+				checksum := mdmWindowsProfileChecksum(p.SyncML)
+				if _, err := tx.ExecContext(ctx, `
+INSERT INTO mdm_windows_configuration_profiles (profile_uuid, team_id, name, syncml, checksum)
+VALUES (UUID(), ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE
+  syncml = VALUES(syncml),
+  checksum = VALUES(checksum)`, profTeamID, p.Name, p.SyncML, checksum); err != nil {
+					return ctxerr.Wrapf(ctx, err, "importing profile %q", p.Name)
+				}
+			}
+			return nil
+		}
+		return ds.batchSetMDMWindowsProfilesDB(ctx, tx, teamID, incoming)
+	})
+	if err != nil {
+		return MDMWindowsProfilesImportReport{}, err
+	}
+
+	return report, nil
+}
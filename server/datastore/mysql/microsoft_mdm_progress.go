@@ -0,0 +1,105 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+// GetHostMDMWindowsProfilesProgress aggregates host_mdm_windows_profiles for
+// hostUUID into per-status totals plus a percent-complete and ETA estimate.
+// It applies the same "NULL status means pending" and
+// remove+verifying/verified masking rules as GetHostMDMWindowsProfiles, so
+// Total here always matches the number of rows that endpoint returns.
+func (ds *Datastore) GetHostMDMWindowsProfilesProgress(ctx context.Context, hostUUID string) (*fleet.MDMProfileProgress, error) {
+	stmt := fmt.Sprintf(`
+SELECT
+	COALESCE(status, '%s') AS status,
+	created_at,
+	updated_at
+FROM
+	host_mdm_windows_profiles
+WHERE
+host_uuid = ? AND NOT (operation_type = '%s' AND COALESCE(status, '%s') IN('%s', '%s'))`,
+		fleet.MDMDeliveryPending,
+		fleet.MDMOperationTypeRemove,
+		fleet.MDMDeliveryPending,
+		fleet.MDMDeliveryVerifying,
+		fleet.MDMDeliveryVerified,
+	)
+
+	var rows []mdmWindowsProfileProgressRow
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &rows, stmt, hostUUID); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "loading host mdm windows profiles progress")
+	}
+
+	return aggregateMDMWindowsProfilesProgress(hostUUID, rows), nil
+}
+
+// mdmWindowsProfileProgressRow is a single host_mdm_windows_profiles row as
+// selected by GetHostMDMWindowsProfilesProgress's query, which always
+// COALESCEs a NULL status to fleet.MDMDeliveryPending before Status is
+// populated - "no status yet" and "explicitly pending" are the same thing
+// for progress purposes.
+type mdmWindowsProfileProgressRow struct {
+	Status    string    `db:"status"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// aggregateMDMWindowsProfilesProgress folds rows into per-status totals plus
+// a computed percent-complete and ETA estimate. It's split out from
+// GetHostMDMWindowsProfilesProgress so the aggregation math can be unit
+// tested without a database.
+func aggregateMDMWindowsProfilesProgress(hostUUID string, rows []mdmWindowsProfileProgressRow) *fleet.MDMProfileProgress {
+	progress := &fleet.MDMProfileProgress{HostUUID: hostUUID}
+
+	var completedDuration time.Duration
+	var completedCount uint
+	for _, r := range rows {
+		progress.Total++
+		switch r.Status {
+		case string(fleet.MDMDeliveryPending):
+			progress.Pending++
+		case string(fleet.MDMDeliveryVerifying):
+			progress.Verifying++
+		case string(fleet.MDMDeliveryVerified):
+			progress.Verified++
+			completedDuration += r.UpdatedAt.Sub(r.CreatedAt)
+			completedCount++
+		case string(fleet.MDMDeliveryFailed):
+			progress.Failed++
+			completedDuration += r.UpdatedAt.Sub(r.CreatedAt)
+			completedCount++
+		case string(fleet.MDMDeliveryRetriesExhausted):
+			// Retries-exhausted is terminal, same as Failed: the
+			// retry-with-backoff subsystem has given up on this profile, so
+			// it counts toward "done" rather than leaving PercentComplete
+			// stuck below 100.
+			progress.RetriesExhausted++
+			completedDuration += r.UpdatedAt.Sub(r.CreatedAt)
+			completedCount++
+		}
+	}
+
+	if progress.Total > 0 {
+		progress.PercentComplete = float64(progress.Verified+progress.Failed+progress.RetriesExhausted) / float64(progress.Total) * 100
+	}
+
+	// ETA is derived from the average time it took already-settled profiles
+	// (Verified, Failed or RetriesExhausted) to go from created to settled,
+	// projected across whatever's still Pending or Verifying. With no
+	// settled profiles yet there's no basis for an estimate, so ETA is left
+	// nil.
+	if remaining := progress.Pending + progress.Verifying; completedCount > 0 && remaining > 0 {
+		avg := completedDuration / time.Duration(completedCount)
+		eta := time.Now().Add(avg * time.Duration(remaining))
+		progress.ETA = &eta
+	}
+
+	return progress
+}
@@ -0,0 +1,68 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateMDMWindowsProfilesProgress(t *testing.T) {
+	now := time.Now()
+
+	t.Run("NULL status is coalesced to pending before it reaches the aggregator", func(t *testing.T) {
+		// GetHostMDMWindowsProfilesProgress's query always COALESCEs a NULL
+		// status column to fleet.MDMDeliveryPending, so a row the aggregator
+		// sees with that status represents both "explicitly pending" and
+		// "no status yet" - the numerator/denominator must treat them the
+		// same way GetHostMDMWindowsProfiles's list endpoint does.
+		rows := []mdmWindowsProfileProgressRow{
+			{Status: string(fleet.MDMDeliveryPending), CreatedAt: now, UpdatedAt: now},
+			{Status: string(fleet.MDMDeliveryVerified), CreatedAt: now.Add(-time.Hour), UpdatedAt: now},
+		}
+
+		progress := aggregateMDMWindowsProfilesProgress("host-1", rows)
+
+		require.Equal(t, uint(2), progress.Total)
+		assert.Equal(t, uint(1), progress.Pending)
+		assert.Equal(t, uint(1), progress.Verified)
+		assert.Equal(t, float64(50), progress.PercentComplete)
+	})
+
+	t.Run("no rows", func(t *testing.T) {
+		progress := aggregateMDMWindowsProfilesProgress("host-1", nil)
+		assert.Equal(t, uint(0), progress.Total)
+		assert.Equal(t, float64(0), progress.PercentComplete)
+		assert.Nil(t, progress.ETA)
+	})
+
+	t.Run("verified, failed and retries_exhausted all count toward percent complete", func(t *testing.T) {
+		rows := []mdmWindowsProfileProgressRow{
+			{Status: string(fleet.MDMDeliveryVerified), CreatedAt: now.Add(-time.Hour), UpdatedAt: now},
+			{Status: string(fleet.MDMDeliveryFailed), CreatedAt: now.Add(-time.Hour), UpdatedAt: now},
+			{Status: string(fleet.MDMDeliveryRetriesExhausted), CreatedAt: now.Add(-time.Hour), UpdatedAt: now},
+			{Status: string(fleet.MDMDeliveryVerifying), CreatedAt: now, UpdatedAt: now},
+		}
+
+		progress := aggregateMDMWindowsProfilesProgress("host-1", rows)
+
+		require.Equal(t, uint(4), progress.Total)
+		assert.Equal(t, uint(1), progress.Verifying)
+		assert.Equal(t, float64(75), progress.PercentComplete)
+		require.NotNil(t, progress.ETA)
+	})
+
+	t.Run("all settled reaches 100 percent with no ETA", func(t *testing.T) {
+		rows := []mdmWindowsProfileProgressRow{
+			{Status: string(fleet.MDMDeliveryVerified), CreatedAt: now.Add(-time.Hour), UpdatedAt: now},
+			{Status: string(fleet.MDMDeliveryRetriesExhausted), CreatedAt: now.Add(-time.Hour), UpdatedAt: now},
+		}
+
+		progress := aggregateMDMWindowsProfilesProgress("host-1", rows)
+
+		assert.Equal(t, float64(100), progress.PercentComplete)
+		assert.Nil(t, progress.ETA, "no pending/verifying rows left, so there's nothing to estimate")
+	})
+}
@@ -0,0 +1,135 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+// MDMWindowsRetryBackoffConfig controls MDMWindowsReissueStalledProfiles'
+// retry schedule: the nth retry (n starting at 0) waits
+// min(Base*2^n, Max), plus up to Jitter fraction of that delay applied
+// randomly, so a large fleet stuck on the same profile doesn't retry in
+// lockstep.
+type MDMWindowsRetryBackoffConfig struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter float64
+	// MaxAttempts is how many retries to make before giving up and marking
+	// the row retries_exhausted. Zero means unlimited.
+	MaxAttempts uint
+}
+
+func (c MDMWindowsRetryBackoffConfig) delay(attempt uint) time.Duration {
+	base := c.Base
+	if base <= 0 {
+		base = time.Minute
+	}
+	max := c.Max
+	if max <= 0 {
+		max = time.Hour
+	}
+
+	d := time.Duration(float64(base) * math.Pow(2, float64(attempt)))
+	if d <= 0 || d > max {
+		d = max
+	}
+	if c.Jitter > 0 {
+		d += time.Duration(c.Jitter * float64(d) * rand.Float64())
+	}
+	return d
+}
+
+// ListStalledHostMDMWindowsProfiles returns host_mdm_windows_profiles rows
+// that are effectively pending install (NULL or 'pending' status) and
+// haven't been updated in at least olderThan, oldest first. Rows already
+// retries_exhausted are excluded, since they're done retrying by design.
+func (ds *Datastore) ListStalledHostMDMWindowsProfiles(ctx context.Context, olderThan time.Duration) ([]*fleet.HostMDMWindowsProfile, error) {
+	stmt := fmt.Sprintf(`
+SELECT
+    host_uuid,
+    profile_uuid,
+    profile_name AS name,
+    COALESCE(status, '%s') AS status,
+    operation_type,
+    COALESCE(detail, '') AS detail,
+    command_uuid,
+    retry_attempts,
+    updated_at
+FROM
+    host_mdm_windows_profiles
+WHERE
+    operation_type = ?
+    AND (status IS NULL OR status = ?)
+    AND updated_at < ?
+ORDER BY
+    updated_at ASC`,
+		fleet.MDMDeliveryPending,
+	)
+
+	var profiles []*fleet.HostMDMWindowsProfile
+	if err := sqlx.SelectContext(ctx, ds.reader(ctx), &profiles, stmt,
+		fleet.MDMOperationTypeInstall, fleet.MDMDeliveryPending, time.Now().Add(-olderThan)); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "listing stalled windows mdm profiles")
+	}
+	return profiles, nil
+}
+
+// MDMWindowsReissueStalledProfiles is the cron entry point for the
+// retry-with-backoff subsystem. It lists profiles stalled for at least
+// cfg's initial delay, and for each one whose individual backoff window has
+// elapsed, resets it to NULL status so the ordinary install cron picks it
+// back up and reissues the command, bumping retry_attempts; once
+// cfg.MaxAttempts is exceeded it instead marks the row retries_exhausted, a
+// terminal state distinct from MDMDeliveryFailed so operators can tell "the
+// device rejected this" from "we stopped trying" and filter accordingly.
+func (ds *Datastore) MDMWindowsReissueStalledProfiles(ctx context.Context, cfg MDMWindowsRetryBackoffConfig) (int, error) {
+	stalled, err := ds.ListStalledHostMDMWindowsProfiles(ctx, cfg.delay(0))
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var reissued int
+	err = ds.withRetryTxx(ctx, func(tx sqlx.ExtContext) error {
+		for _, p := range stalled {
+			due := p.UpdatedAt.Add(cfg.delay(p.RetryAttempts))
+			if now.Before(due) {
+				continue
+			}
+
+			if cfg.MaxAttempts > 0 && p.RetryAttempts >= cfg.MaxAttempts {
+				if _, err := tx.ExecContext(ctx, `
+UPDATE host_mdm_windows_profiles
+SET status = ?, detail = ?
+WHERE host_uuid = ? AND profile_uuid = ?`,
+					fleet.MDMDeliveryRetriesExhausted,
+					"Exceeded maximum retry attempts for this profile.",
+					p.HostUUID, p.ProfileUUID); err != nil {
+					return ctxerr.Wrap(ctx, err, "marking windows mdm profile retries exhausted")
+				}
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+UPDATE host_mdm_windows_profiles
+SET status = NULL, command_uuid = '', retry_attempts = retry_attempts + 1,
+    last_retry_error = detail, last_retried_at = ?
+WHERE host_uuid = ? AND profile_uuid = ?`, now, p.HostUUID, p.ProfileUUID); err != nil {
+				return ctxerr.Wrap(ctx, err, "resetting stalled windows mdm profile for retry")
+			}
+			reissued++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return reissued, nil
+}
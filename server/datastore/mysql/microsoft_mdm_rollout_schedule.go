@@ -0,0 +1,354 @@
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fleetdm/fleet/v4/server/contexts/ctxerr"
+	"github.com/fleetdm/fleet/v4/server/fleet"
+	"github.com/jmoiron/sqlx"
+)
+
+// mdmWindowsRolloutSchedule is the JSON shape stored in the
+// mdm_windows_configuration_profiles.rollout_schedule column. It lets
+// operators stage a profile's install/remove to a maintenance window
+// instead of having it apply to every enrolled Windows host the moment
+// gitops runs.
+type mdmWindowsRolloutSchedule struct {
+	// Cron is a standard 5-field cron expression (minute hour dom month
+	// dow), supporting *, ranges (1-5), steps (*/15) and comma lists
+	// (1,15,30).
+	Cron string `json:"cron"`
+	// TZ is an IANA time zone name (e.g. "America/Los_Angeles"). Empty
+	// means UTC.
+	TZ string `json:"tz"`
+	// DwellMinutes is how long, after the cron expression last fired, the
+	// window is considered active. Defaults to 60 if zero.
+	DwellMinutes uint `json:"dwell_minutes"`
+}
+
+const defaultRolloutDwell = time.Hour
+
+// parseMDMWindowsRolloutSchedule unmarshals raw (the rollout_schedule
+// column's JSON, which may be nil/empty to mean "no schedule, always
+// active").
+func parseMDMWindowsRolloutSchedule(raw []byte) (*mdmWindowsRolloutSchedule, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var s mdmWindowsRolloutSchedule
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, fmt.Errorf("parsing rollout schedule: %w", err)
+	}
+	if s.Cron == "" {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// active reports whether now falls inside a firing interval of s: i.e. the
+// cron expression's most recent tick at or before now (in s's timezone) is
+// within s's dwell duration.
+func (s *mdmWindowsRolloutSchedule) active(now time.Time) (bool, error) {
+	if s == nil {
+		return true, nil
+	}
+
+	loc := time.UTC
+	if s.TZ != "" {
+		l, err := time.LoadLocation(s.TZ)
+		if err != nil {
+			return false, fmt.Errorf("loading rollout schedule timezone %q: %w", s.TZ, err)
+		}
+		loc = l
+	}
+
+	sched, err := parseCronExpr(s.Cron)
+	if err != nil {
+		return false, err
+	}
+
+	dwell := defaultRolloutDwell
+	if s.DwellMinutes > 0 {
+		dwell = time.Duration(s.DwellMinutes) * time.Minute
+	}
+
+	localNow := now.In(loc)
+	prev, ok := sched.prevFireTime(localNow)
+	if !ok {
+		return false, nil
+	}
+	return localNow.Sub(prev) <= dwell, nil
+}
+
+// cronExpr is a parsed 5-field cron expression (minute hour dom month dow).
+type cronExpr struct {
+	minute, hour, dom, month, dow cronField
+}
+
+// cronField is the set of matching values for one cron field.
+type cronField map[int]bool
+
+func parseCronExpr(expr string) (*cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", expr, len(fields))
+	}
+
+	minute, err := parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return nil, fmt.Errorf("minute field: %w", err)
+	}
+	hour, err := parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return nil, fmt.Errorf("hour field: %w", err)
+	}
+	dom, err := parseCronField(fields[2], 1, 31)
+	if err != nil {
+		return nil, fmt.Errorf("day-of-month field: %w", err)
+	}
+	month, err := parseCronField(fields[3], 1, 12)
+	if err != nil {
+		return nil, fmt.Errorf("month field: %w", err)
+	}
+	dow, err := parseCronField(fields[4], 0, 6)
+	if err != nil {
+		return nil, fmt.Errorf("day-of-week field: %w", err)
+	}
+
+	return &cronExpr{minute: minute, hour: hour, dom: dom, month: month, dow: dow}, nil
+}
+
+// parseCronField parses a single cron field (*, a, a-b, a-b/step, */step, or
+// a comma-separated list of any of those) into the set of matching values in
+// [min, max].
+func parseCronField(field string, min, max int) (cronField, error) {
+	result := make(cronField)
+	for _, part := range strings.Split(field, ",") {
+		lo, hi, step := min, max, 1
+
+		valuePart, stepPart, hasStep := strings.Cut(part, "/")
+		if hasStep {
+			s, err := strconv.Atoi(stepPart)
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			step = s
+		}
+
+		switch {
+		case valuePart == "*":
+			// lo/hi already the full range
+		case strings.Contains(valuePart, "-"):
+			loStr, hiStr, _ := strings.Cut(valuePart, "-")
+			l, err := strconv.Atoi(loStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range start in %q", part)
+			}
+			h, err := strconv.Atoi(hiStr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range end in %q", part)
+			}
+			lo, hi = l, h
+		default:
+			v, err := strconv.Atoi(valuePart)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			lo, hi = v, v
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("value out of range [%d, %d] in %q", min, max, part)
+		}
+		for v := lo; v <= hi; v += step {
+			result[v] = true
+		}
+	}
+	return result, nil
+}
+
+// maxCronLookback bounds how far prevFireTime will search backward before
+// giving up. Any expression that fires at least once a year is found; one
+// that only matches rarer dates (e.g. February 29th) may not be.
+const maxCronLookback = 366 * 24 * time.Hour
+
+// prevFireTime returns the latest tick at or before now that matches e,
+// truncated to the minute, or ok=false if none was found within
+// maxCronLookback. Rather than testing every minute back to the limit, it
+// jumps directly to the last instant of the previous month/day/hour whenever
+// the current one doesn't match, so a schedule whose window last fired long
+// ago is found in a number of steps proportional to the calendar distance
+// (months, then days, then hours), not to the number of minutes in it.
+func (e *cronExpr) prevFireTime(now time.Time) (time.Time, bool) {
+	t := now.Truncate(time.Minute)
+	limit := t.Add(-maxCronLookback)
+	loc := t.Location()
+
+	for {
+		if t.Before(limit) {
+			return time.Time{}, false
+		}
+		switch {
+		case !e.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case !e.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc).Add(-time.Minute)
+		case !e.hour[t.Hour()]:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc).Add(-time.Minute)
+		case !e.minute[t.Minute()]:
+			t = t.Add(-time.Minute)
+		default:
+			return t, true
+		}
+	}
+}
+
+// dayMatches reports whether t's day-of-month or day-of-week (depending on
+// how they're restricted) satisfies e, independent of hour and minute.
+// Cron treats day-of-month and day-of-week as OR'd when both are restricted
+// (i.e. not "*"), and AND'd (effectively just whichever is restricted)
+// otherwise - this matches standard cron semantics.
+func (e *cronExpr) dayMatches(t time.Time) bool {
+	domIsAny := len(e.dom) == 31
+	dowIsAny := len(e.dow) == 7
+	domMatch := e.dom[t.Day()]
+	dowMatch := e.dow[int(t.Weekday())]
+
+	switch {
+	case domIsAny && dowIsAny:
+		return true
+	case domIsAny:
+		return dowMatch
+	case dowIsAny:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// matches reports whether t satisfies every field of e.
+func (e *cronExpr) matches(t time.Time) bool {
+	return e.minute[t.Minute()] && e.hour[t.Hour()] && e.month[int(t.Month())] && e.dayMatches(t)
+}
+
+// filterMDMWindowsProfilesByRolloutSchedule drops (host, profile) pairs whose
+// profile has a configured rollout_schedule that isn't in its active window
+// right now, so a maintenance-windowed profile only surfaces from
+// ListMDMWindowsProfilesToInstall/ToRemove during the window it's scheduled
+// for; outside the window it's left exactly as-is (still pending) until the
+// next window comes around.
+func filterMDMWindowsProfilesByRolloutSchedule(
+	ctx context.Context,
+	tx sqlx.ExtContext,
+	payloads []*fleet.MDMWindowsProfilePayload,
+) ([]*fleet.MDMWindowsProfilePayload, error) {
+	if len(payloads) == 0 {
+		return payloads, nil
+	}
+
+	uuids := make([]string, 0, len(payloads))
+	seen := make(map[string]bool, len(payloads))
+	for _, p := range payloads {
+		if !seen[p.ProfileUUID] {
+			seen[p.ProfileUUID] = true
+			uuids = append(uuids, p.ProfileUUID)
+		}
+	}
+
+	stmt, args, err := sqlx.In(`
+SELECT profile_uuid, rollout_schedule
+FROM mdm_windows_configuration_profiles
+WHERE profile_uuid IN (?) AND rollout_schedule IS NOT NULL`, uuids)
+	if err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "building sqlx.In for rollout schedules")
+	}
+
+	var rows []struct {
+		ProfileUUID     string `db:"profile_uuid"`
+		RolloutSchedule []byte `db:"rollout_schedule"`
+	}
+	if err := sqlx.SelectContext(ctx, tx, &rows, stmt, args...); err != nil {
+		return nil, ctxerr.Wrap(ctx, err, "loading rollout schedules")
+	}
+	if len(rows) == 0 {
+		return payloads, nil
+	}
+
+	now := time.Now()
+	activeByProfile := make(map[string]bool, len(rows))
+	for _, r := range rows {
+		schedule, err := parseMDMWindowsRolloutSchedule(r.RolloutSchedule)
+		if err != nil {
+			return nil, ctxerr.Wrapf(ctx, err, "parsing rollout schedule for profile %s", r.ProfileUUID)
+		}
+		active, err := schedule.active(now)
+		if err != nil {
+			return nil, ctxerr.Wrapf(ctx, err, "evaluating rollout schedule for profile %s", r.ProfileUUID)
+		}
+		activeByProfile[r.ProfileUUID] = active
+	}
+
+	filtered := payloads[:0]
+	for _, p := range payloads {
+		if active, scheduled := activeByProfile[p.ProfileUUID]; !scheduled || active {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered, nil
+}
+
+// countMDMWindowsScheduledHostsDB counts the distinct hosts that are
+// currently pending a profile install solely because that profile's rollout
+// window isn't active yet, for GetMDMWindowsProfilesSummary's "scheduled"
+// bucket. It's a best-effort count: it re-runs the same desired-state query
+// as ListMDMWindowsProfilesToInstall (scoped to teamID) and checks each
+// matched profile's window in Go, since a cron expression can't be evaluated
+// in SQL.
+func countMDMWindowsScheduledHostsDB(ctx context.Context, tx sqlx.ExtContext, teamID *uint) (uint, error) {
+	var tid uint
+	if teamID != nil {
+		tid = *teamID
+	}
+
+	var rows []struct {
+		HostUUID        string `db:"host_uuid"`
+		RolloutSchedule []byte `db:"rollout_schedule"`
+	}
+	stmt := `
+SELECT h.uuid as host_uuid, mwcp.rollout_schedule
+FROM mdm_windows_configuration_profiles mwcp
+JOIN hosts h ON h.team_id = mwcp.team_id OR (h.team_id IS NULL AND mwcp.team_id = 0)
+JOIN mdm_windows_enrollments mwe ON mwe.host_uuid = h.uuid
+LEFT JOIN host_mdm_windows_profiles hmwp
+    ON hmwp.profile_uuid = mwcp.profile_uuid AND hmwp.host_uuid = h.uuid
+WHERE h.platform = 'windows'
+  AND mwcp.team_id = ?
+  AND mwcp.rollout_schedule IS NOT NULL
+  AND (hmwp.host_uuid IS NULL OR (hmwp.operation_type = ? AND hmwp.status IS NULL))`
+	if err := sqlx.SelectContext(ctx, tx, &rows, stmt, tid, fleet.MDMOperationTypeInstall); err != nil {
+		return 0, ctxerr.Wrap(ctx, err, "loading candidate hosts for scheduled rollout count")
+	}
+
+	now := time.Now()
+	scheduledHosts := make(map[string]bool, len(rows))
+	for _, r := range rows {
+		schedule, err := parseMDMWindowsRolloutSchedule(r.RolloutSchedule)
+		if err != nil {
+			return 0, ctxerr.Wrap(ctx, err, "parsing rollout schedule for scheduled count")
+		}
+		active, err := schedule.active(now)
+		if err != nil {
+			return 0, ctxerr.Wrap(ctx, err, "evaluating rollout schedule for scheduled count")
+		}
+		if !active {
+			scheduledHosts[r.HostUUID] = true
+		}
+	}
+
+	return uint(len(scheduledHosts)), nil
+}
@@ -0,0 +1,118 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronField(t *testing.T) {
+	cases := []struct {
+		name       string
+		field      string
+		min, max   int
+		wantValues []int
+		wantErr    bool
+	}{
+		{name: "wildcard", field: "*", min: 0, max: 4, wantValues: []int{0, 1, 2, 3, 4}},
+		{name: "single value", field: "5", min: 0, max: 59, wantValues: []int{5}},
+		{name: "range", field: "1-3", min: 0, max: 59, wantValues: []int{1, 2, 3}},
+		{name: "step", field: "*/15", min: 0, max: 59, wantValues: []int{0, 15, 30, 45}},
+		{name: "range with step", field: "0-10/5", min: 0, max: 59, wantValues: []int{0, 5, 10}},
+		{name: "comma list", field: "1,15,30", min: 0, max: 59, wantValues: []int{1, 15, 30}},
+		{name: "out of range", field: "60", min: 0, max: 59, wantErr: true},
+		{name: "invalid value", field: "foo", min: 0, max: 59, wantErr: true},
+		{name: "zero step", field: "*/0", min: 0, max: 59, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseCronField(c.field, c.min, c.max)
+			if c.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for _, v := range c.wantValues {
+				assert.True(t, got[v], "expected %d to match field %q", v, c.field)
+			}
+			assert.Len(t, got, len(c.wantValues))
+		})
+	}
+}
+
+func TestParseCronExprInvalid(t *testing.T) {
+	_, err := parseCronExpr("* * * *")
+	assert.Error(t, err, "cron expression with fewer than 5 fields should fail")
+}
+
+func TestCronExprMatches(t *testing.T) {
+	// fires every day at 09:30
+	sched, err := parseCronExpr("30 9 * * *")
+	require.NoError(t, err)
+
+	assert.True(t, sched.matches(time.Date(2026, 7, 28, 9, 30, 0, 0, time.UTC)))
+	assert.False(t, sched.matches(time.Date(2026, 7, 28, 9, 31, 0, 0, time.UTC)))
+	assert.False(t, sched.matches(time.Date(2026, 7, 28, 10, 30, 0, 0, time.UTC)))
+}
+
+func TestCronExprPrevFireTime(t *testing.T) {
+	// fires daily at 02:00
+	sched, err := parseCronExpr("0 2 * * *")
+	require.NoError(t, err)
+
+	now := time.Date(2026, 7, 28, 10, 15, 0, 0, time.UTC)
+	prev, ok := sched.prevFireTime(now)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 7, 28, 2, 0, 0, 0, time.UTC), prev)
+
+	// now is before today's tick, so the last fire was yesterday
+	now = time.Date(2026, 7, 28, 1, 0, 0, 0, time.UTC)
+	prev, ok = sched.prevFireTime(now)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 7, 27, 2, 0, 0, 0, time.UTC), prev)
+}
+
+func TestCronExprPrevFireTimeCrossesMonthAndYear(t *testing.T) {
+	// fires once a year, January 1st at midnight - exercises the month/day
+	// jump logic rather than a simple same-month lookup.
+	sched, err := parseCronExpr("0 0 1 1 *")
+	require.NoError(t, err)
+
+	now := time.Date(2026, 7, 28, 10, 15, 0, 0, time.UTC)
+	prev, ok := sched.prevFireTime(now)
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), prev)
+}
+
+func TestCronExprPrevFireTimeNoMatchWithinLookback(t *testing.T) {
+	// February 30th never exists, so this schedule never fires.
+	sched, err := parseCronExpr("0 0 30 2 *")
+	require.NoError(t, err)
+
+	_, ok := sched.prevFireTime(time.Date(2026, 7, 28, 0, 0, 0, 0, time.UTC))
+	assert.False(t, ok)
+}
+
+func TestMDMWindowsRolloutScheduleActive(t *testing.T) {
+	s := &mdmWindowsRolloutSchedule{Cron: "0 2 * * *", DwellMinutes: 60}
+
+	withinWindow := time.Date(2026, 7, 28, 2, 30, 0, 0, time.UTC)
+	active, err := s.active(withinWindow)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	afterWindow := time.Date(2026, 7, 28, 4, 0, 0, 0, time.UTC)
+	active, err = s.active(afterWindow)
+	require.NoError(t, err)
+	assert.False(t, active)
+}
+
+func TestMDMWindowsRolloutScheduleActiveNilIsAlwaysActive(t *testing.T) {
+	var s *mdmWindowsRolloutSchedule
+	active, err := s.active(time.Now())
+	require.NoError(t, err)
+	assert.True(t, active)
+}
@@ -0,0 +1,45 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20260324000001, Down_20260324000001)
+}
+
+func Up_20260324000001(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE windows_mdm_commands
+    ADD COLUMN command_state INT UNSIGNED NOT NULL DEFAULT 0`)
+	if err != nil {
+		return errors.Wrap(err, "add command_state to windows_mdm_commands")
+	}
+
+	_, err = tx.Exec(`
+CREATE TABLE windows_mdm_command_conflicts (
+    id                 BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+    enrollment_id      INT(10) UNSIGNED NOT NULL,
+    command_uuid       VARCHAR(127) NOT NULL,
+    response_id        BIGINT(20) UNSIGNED NOT NULL,
+    expected_state     INT UNSIGNED NOT NULL,
+    raw_result         MEDIUMBLOB NULL,
+    status_code        VARCHAR(10) NOT NULL DEFAULT '',
+    conflict_type      VARCHAR(32) NOT NULL,
+    created_at         TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY idx_windows_mdm_command_conflicts_enrollment_id (enrollment_id),
+    KEY idx_windows_mdm_command_conflicts_command_uuid (command_uuid)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create windows_mdm_command_conflicts")
+	}
+
+	return nil
+}
+
+func Down_20260324000001(tx *sql.Tx) error {
+	return nil
+}
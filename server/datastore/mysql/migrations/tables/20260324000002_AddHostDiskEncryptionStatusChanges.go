@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20260324000002, Down_20260324000002)
+}
+
+func Up_20260324000002(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+CREATE TABLE host_disk_encryption_status_changes (
+    id              BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+    host_id         INT(10) UNSIGNED NOT NULL,
+    previous_status VARCHAR(32) NOT NULL DEFAULT '',
+    current_status  VARCHAR(32) NOT NULL DEFAULT '',
+    detail          VARCHAR(255) NOT NULL DEFAULT '',
+    created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY idx_host_disk_encryption_status_changes_host_id (host_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create host_disk_encryption_status_changes")
+	}
+	return nil
+}
+
+func Down_20260324000002(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,45 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20260324000003, Down_20260324000003)
+}
+
+func Up_20260324000003(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE windows_mdm_commands
+    ADD COLUMN ttl_seconds INT UNSIGNED NULL DEFAULT NULL`)
+	if err != nil {
+		return errors.Wrap(err, "add ttl_seconds to windows_mdm_commands")
+	}
+
+	_, err = tx.Exec(`
+CREATE TABLE windows_mdm_command_dead_letter (
+    id                   BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+    command_uuid         VARCHAR(127) NOT NULL,
+    enrollment_id         INT(10) UNSIGNED NOT NULL,
+    host_uuid             VARCHAR(127) NOT NULL,
+    raw_command           MEDIUMBLOB NOT NULL,
+    target_loc_uri        VARCHAR(255) NOT NULL,
+    last_status           VARCHAR(10) NOT NULL DEFAULT '',
+    original_created_at   TIMESTAMP NOT NULL,
+    dead_lettered_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY idx_windows_mdm_command_dead_letter_cmd_host (command_uuid, host_uuid),
+    KEY idx_windows_mdm_command_dead_letter_host_uuid (host_uuid)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`)
+	if err != nil {
+		return errors.Wrap(err, "create windows_mdm_command_dead_letter")
+	}
+
+	return nil
+}
+
+func Down_20260324000003(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20260324000004, Down_20260324000004)
+}
+
+func Up_20260324000004(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE mdm_windows_configuration_profiles
+    ADD COLUMN checksum BINARY(32) NULL DEFAULT NULL`)
+	if err != nil {
+		return errors.Wrap(err, "add checksum to mdm_windows_configuration_profiles")
+	}
+
+	_, err = tx.Exec(`
+ALTER TABLE host_mdm_windows_profiles
+    ADD COLUMN checksum BINARY(32) NULL DEFAULT NULL`)
+	if err != nil {
+		return errors.Wrap(err, "add checksum to host_mdm_windows_profiles")
+	}
+
+	return nil
+}
+
+func Down_20260324000004(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20260324000005, Down_20260324000005)
+}
+
+func Up_20260324000005(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE mdm_windows_configuration_profiles
+    ADD COLUMN rollout_schedule JSON NULL DEFAULT NULL`)
+	if err != nil {
+		return errors.Wrap(err, "add rollout_schedule to mdm_windows_configuration_profiles")
+	}
+
+	return nil
+}
+
+func Down_20260324000005(tx *sql.Tx) error {
+	return nil
+}
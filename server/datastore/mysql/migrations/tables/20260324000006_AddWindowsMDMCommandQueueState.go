@@ -0,0 +1,31 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20260324000006, Down_20260324000006)
+}
+
+func Up_20260324000006(tx *sql.Tx) error {
+	// command_state on windows_mdm_commands is shared by every enrollment a
+	// command was fanned out to, so a compare-and-swap against it lets one
+	// host's response starve every other host's response to the same
+	// command. Track the CAS state per (enrollment_id, command_uuid)
+	// instead, on the row that's already scoped that way.
+	_, err := tx.Exec(`
+ALTER TABLE windows_mdm_command_queue
+    ADD COLUMN command_state INT UNSIGNED NOT NULL DEFAULT 0`)
+	if err != nil {
+		return errors.Wrap(err, "add command_state to windows_mdm_command_queue")
+	}
+
+	return nil
+}
+
+func Down_20260324000006(tx *sql.Tx) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+func init() {
+	MigrationClient.AddMigration(Up_20260324000006, Down_20260324000006)
+}
+
+func Up_20260324000006(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+ALTER TABLE host_mdm_windows_profiles
+    ADD COLUMN retry_attempts INT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN last_retry_error TEXT NULL,
+    ADD COLUMN last_retried_at TIMESTAMP NULL DEFAULT NULL`)
+	if err != nil {
+		return errors.Wrap(err, "add retry backoff columns to host_mdm_windows_profiles")
+	}
+
+	return nil
+}
+
+func Down_20260324000006(tx *sql.Tx) error {
+	return nil
+}